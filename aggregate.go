@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// aggregateWriter prints an aggregation of the pairs instead of the pairs,
+// the sum of the values as decimal integers or the number of the pairs
+type aggregateWriter struct {
+	w     io.Writer
+	sum   bool // sum the values, or only count the pairs
+	json  bool // print a JSON object
+	bare  bool // print only the number of --count-only
+	count int64
+	total int64
+	err   error // the failure of a value, nothing is printed after it
+}
+
+func (aw *aggregateWriter) Write(key, val []byte) error {
+	if aw.sum {
+		n, err := strconv.ParseInt(strings.TrimSpace(string(val)), 10, 64)
+		if err != nil {
+			aw.err = fmt.Errorf("%s: the value %q is not an integer", tikvclient.DisplayKey(key), val)
+			return aw.err
+		}
+		if n > 0 && aw.total > math.MaxInt64-n || n < 0 && aw.total < math.MinInt64-n {
+			aw.err = fmt.Errorf("%s: the sum overflows a 64-bit integer", tikvclient.DisplayKey(key))
+			return aw.err
+		}
+		aw.total += n
+	}
+	aw.count++
+	return nil
+}
+
+func (aw *aggregateWriter) Flush() error {
+	if aw.err != nil {
+		return nil
+	}
+	result := aw.count
+	if aw.sum {
+		result = aw.total
+	}
+	if aw.json {
+		obj := map[string]int64{"count": aw.count}
+		if aw.sum {
+			obj["sum"] = aw.total
+		}
+		return json.NewEncoder(aw.w).Encode(obj)
+	}
+	if aw.bare {
+		_, err := fmt.Fprintln(aw.w, result)
+		return err
+	}
+	_, err := fmt.Fprintf(aw.w, "(integer) %d\n", result)
+	return err
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+	"github.com/spf13/pflag"
+)
+
+// benchScanLength is the number of the pairs read by a scan of bench, a
+// scan phase runs one scan per benchScanLength keys
+const benchScanLength = 100
+
+// benchResult is what the workers of a phase measured
+type benchResult struct {
+	latencies []time.Duration
+	errors    int
+	err       error // the first error
+}
+
+// bench writes, reads or scans --keys random keys from --concurrency
+// goroutines and reports the throughput and the latencies of every phase of
+// --ops. Every operation commits its own transaction, at most
+// --max-concurrent-txns of them run at the same time. The keys live under a
+// prefix of their own and are deleted at the end unless --keep.
+func (c *command) bench(args []string) {
+	if len(args) > 0 {
+		c.printError(fmt.Errorf("bench takes no argument"))
+		return
+	}
+	if c.benchOpts.keys <= 0 || c.benchOpts.concurrency <= 0 || c.benchOpts.valueSize < 0 {
+		c.printError(fmt.Errorf("--keys and --concurrency should be greater than 0, --value-size can not be negative"))
+		return
+	}
+	if c.cli.InTxn() {
+		c.printError(fmt.Errorf("bench can not be used in a transaction, every operation commits its own"))
+		return
+	}
+	ops := strings.Split(c.benchOpts.ops, ",")
+	for _, op := range ops {
+		switch op {
+		case "set", "get", "scan":
+		default:
+			c.printError(fmt.Errorf("unknown bench operation %q, should be set, get or scan", op))
+			return
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	prefix := fmt.Sprintf("\x00tikv-cli-bench:%d:", time.Now().UnixNano())
+	keys := make([][]byte, c.benchOpts.keys)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%s%016x", prefix, rnd.Uint64()))
+	}
+	sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+	defer c.benchCleanup([]byte(prefix))
+
+	written := false
+	for _, op := range ops {
+		if op != "set" && !written {
+			// the reads need the keys, writing them is not measured
+			if err := c.benchFill(keys); err != nil {
+				c.printError(err)
+				return
+			}
+			written = true
+		}
+		begin := time.Now()
+		res := c.benchRun(op, keys)
+		elapsed := time.Since(begin)
+		written = written || op == "set"
+		c.benchReport(op, res, elapsed)
+		if err := c.cli.Context().Err(); err != nil {
+			return
+		}
+	}
+}
+
+// benchRun runs a phase, the operations are shared out among the workers
+func (c *command) benchRun(op string, keys [][]byte) benchResult {
+	n := len(keys)
+	if op == "scan" {
+		n = (len(keys) + benchScanLength - 1) / benchScanLength
+	}
+	results := make([]benchResult, c.benchOpts.concurrency)
+	var wg sync.WaitGroup
+	for w := range results {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			res := &results[w]
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(w)))
+			val := make([]byte, c.benchOpts.valueSize)
+			for i := w; i < n && c.cli.Context().Err() == nil; i += c.benchOpts.concurrency {
+				var err error
+				// the waits for a slot of --max-concurrent-txns are not measured
+				release := c.cli.AcquireTxn()
+				begin := time.Now()
+				switch op {
+				case "set":
+					rnd.Read(val)
+					err = c.cli.Set(keys[i], val)
+				case "get":
+					_, err = c.cli.Get(keys[i])
+				case "scan":
+					start := keys[rnd.Intn(len(keys))]
+					_, err = c.cli.ScanRange(start, nil, benchScanLength, nil, func(key, val []byte) bool { return true })
+				}
+				res.latencies = append(res.latencies, time.Since(begin))
+				release()
+				if err != nil {
+					res.errors++
+					if res.err == nil {
+						res.err = err
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var total benchResult
+	for _, res := range results {
+		total.latencies = append(total.latencies, res.latencies...)
+		total.errors += res.errors
+		if total.err == nil {
+			total.err = res.err
+		}
+	}
+	return total
+}
+
+// benchFill writes the keys in transactions of 256 pairs
+func (c *command) benchFill(keys [][]byte) error {
+	val := make([]byte, c.benchOpts.valueSize)
+	rand.Read(val)
+	for i := 0; i < len(keys); i += 256 {
+		end := i + 256
+		if end > len(keys) {
+			end = len(keys)
+		}
+		pairs := make([]kvPair, 0, end-i)
+		for _, key := range keys[i:end] {
+			pairs = append(pairs, kvPair{Key: key, Value: val})
+		}
+		if err := c.cli.SetMany(pairs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// benchReport prints the throughput and the latencies of a phase
+func (c *command) benchReport(op string, res benchResult, elapsed time.Duration) {
+	n := len(res.latencies)
+	if n == 0 {
+		fmt.Printf("%s: no operation ran\n", op)
+		return
+	}
+	sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+	percentile := func(p int) time.Duration {
+		return res.latencies[(n-1)*p/100].Round(time.Microsecond)
+	}
+	fmt.Printf("%s: %d ops in %v, %.0f ops/s, p50 %v, p99 %v", op, n, elapsed.Round(time.Millisecond),
+		float64(n)/elapsed.Seconds(), percentile(50), percentile(99))
+	if res.errors > 0 {
+		fmt.Printf(", %d errors", res.errors)
+	}
+	fmt.Println()
+	if res.err != nil {
+		c.printError(res.err)
+	}
+}
+
+// benchCleanup deletes the keys of the benchmark unless --keep
+func (c *command) benchCleanup(prefix []byte) {
+	if c.benchOpts.keep {
+		fmt.Fprintf(os.Stderr, "the keys are kept under %s\n", tikvclient.DisplayKey(prefix))
+		return
+	}
+	if _, err := c.cli.DeletePrefix(prefix, 256, nil); err != nil {
+		c.printError(err)
+		fmt.Fprintf(os.Stderr, "the keys are left under %s\n", tikvclient.DisplayKey(prefix))
+	}
+}
+
+func (c *command) benchFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.benchOpts.ops, "ops", "set,get", "comma separated phases run in order: set, get or scan")
+	fs.IntVar(&c.benchOpts.keys, "keys", 10000, "number of the random keys, and of the operations of a set or get phase")
+	fs.IntVarP(&c.benchOpts.concurrency, "concurrency", "c", 16, "number of the goroutines running the operations")
+	fs.IntVar(&c.benchOpts.valueSize, "value-size", 64, "size of the random values in bytes")
+	fs.BoolVar(&c.benchOpts.keep, "keep", false, "keep the keys instead of deleting them at the end")
+}
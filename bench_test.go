@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/kv"
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// inflightStore tracks the transactions begun and not yet committed or
+// rolled back, they are held a little so the concurrent ones overlap
+type inflightStore struct {
+	tikvclient.Store
+	mu        sync.Mutex
+	open, max int
+}
+
+func (s *inflightStore) Begin() (kv.Transaction, error) {
+	txn, err := s.Store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	s.add(1)
+	return &inflightTxn{Transaction: txn, store: s}, nil
+}
+
+func (s *inflightStore) add(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open += n
+	if s.open > s.max {
+		s.max = s.open
+	}
+}
+
+// inflightTxn leaves the count on its commit or rollback, whichever comes
+// first since a rollback after the commit is a no-op
+type inflightTxn struct {
+	kv.Transaction
+	store *inflightStore
+	done  sync.Once
+}
+
+func (txn *inflightTxn) end() {
+	txn.done.Do(func() {
+		time.Sleep(100 * time.Microsecond)
+		txn.store.add(-1)
+	})
+}
+
+func (txn *inflightTxn) Commit(ctx context.Context) error {
+	defer txn.end()
+	return txn.Transaction.Commit(ctx)
+}
+
+func (txn *inflightTxn) Rollback() error {
+	defer txn.end()
+	return txn.Transaction.Rollback()
+}
+
+func TestBenchMaxConcurrentTxns(t *testing.T) {
+	c := newTestCommand(t)
+	store := &inflightStore{Store: tikvclient.NewMemStore()}
+	c.cli = tikvclient.NewClient(store)
+	c.cli.SetMaxConcurrentTxns(4)
+
+	out := output(t, c, "bench --ops set,get,scan --keys 500 -c 32 --value-size 8")
+	for _, op := range []string{"set: 500 ops", "get: 500 ops", "scan: 5 ops"} {
+		if !strings.Contains(out, op) {
+			t.Errorf("got %q, want %s", out, op)
+		}
+	}
+	if strings.Contains(out, "errors") {
+		t.Fatalf("got %q", out)
+	}
+	if store.max > 4 {
+		t.Fatalf("%d transactions were open at the same time, the cap is 4", store.max)
+	}
+	if store.open != 0 {
+		t.Fatalf("%d transactions are still open", store.open)
+	}
+}
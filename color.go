@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/mattn/go-isatty"
+)
+
+// the ANSI colors of the text output on a terminal
+const (
+	keyColor     = "\x1b[36m" // cyan
+	valueColor   = "\x1b[32m" // green
+	missingColor = "\x1b[2m"  // faint
+	colorReset   = "\x1b[0m"
+)
+
+// alignBlock is the number of pairs aligned together by scan --align, the
+// output is not held back longer than that
+const alignBlock = 256
+
+// colorEnabled reports whether the text output to w is colorized, only a
+// terminal gets the escape codes so pipes and files stay plain
+func colorEnabled(w io.Writer, opts *Options) bool {
+	if opts.NoColor || !opts.plain() || opts.KeySplit != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	return ok && isatty.IsTerminal(f.Fd())
+}
+
+// paint wraps s in the color if on
+func paint(on bool, color, s string) string {
+	if !on {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// colorWriter prints the pairs of the text output to a terminal, the quoted
+// keys and values in different colors separated by a tab
+type colorWriter struct {
+	w  io.Writer
+	tw *tabwriter.Writer // aligns the values, nil unless alignColumns
+	n  int
+}
+
+// alignColumns pads the keys so the values of every block of alignBlock pairs
+// start in the same column, it returns the writer of anything printed before
+// a pair on the same line
+func (cw *colorWriter) alignColumns() io.Writer {
+	cw.tw = tabwriter.NewWriter(cw.w, 0, 8, 2, ' ', 0)
+	return cw.tw
+}
+
+func (cw *colorWriter) Write(key, val []byte) error {
+	v := paint(true, missingColor, missing)
+	if val != nil {
+		v = paint(true, valueColor, strconv.Quote(string(val)))
+	}
+	line := paint(true, keyColor, strconv.Quote(string(key))) + "\t" + v + "\n"
+	if cw.tw == nil {
+		_, err := io.WriteString(cw.w, line)
+		return err
+	}
+	if _, err := io.WriteString(cw.tw, line); err != nil {
+		return err
+	}
+	cw.n++
+	if cw.n%alignBlock == 0 {
+		return cw.tw.Flush()
+	}
+	return nil
+}
+
+func (cw *colorWriter) Flush() error {
+	if cw.tw == nil {
+		return nil
+	}
+	return cw.tw.Flush()
+}
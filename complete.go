@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	prompt "github.com/c-bata/go-prompt"
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+const (
+	// completeLimit is the max number of keys suggested
+	completeLimit = 20
+	// completeTTL is how long the keys of a prefix are reused, so typing
+	// does not scan on every keystroke
+	completeTTL = 2 * time.Second
+	// completeTimeout bounds the scan of a completion, a slow cluster only
+	// loses the suggestions
+	completeTimeout = 300 * time.Millisecond
+)
+
+// keyArgs are the commands completing every argument with the existing keys,
+// the others in firstKeyArg complete only their first argument
+var keyArgs = map[string]bool{
+	"get": true, "mget": true, "delete": true, "exists": true,
+}
+
+var firstKeyArg = map[string]bool{
+	"set": true, "incr": true, "decr": true, "rename": true, "scan": true, "count": true, "dump": true,
+}
+
+// keyCompletion is the cached result of a completion scan
+type keyCompletion struct {
+	keyspace string
+	prefix   []byte
+	keys     [][]byte
+	complete bool // all the keys with the prefix were found
+	at       time.Time
+}
+
+// complete suggests the commands for the first word of the line and the
+// existing keys for the key arguments
+func (c *command) complete(d prompt.Document) []prompt.Suggest {
+	words := strings.Split(d.TextBeforeCursor(), " ")
+	if len(words) < 2 {
+		return promptCompleter(d)
+	}
+	word := words[len(words)-1]
+	if strings.HasPrefix(word, "-") || !keyArgs[words[0]] && !(firstKeyArg[words[0]] && len(words) == 2) {
+		return nil
+	}
+	prefix, err := c.decodeKey(word)
+	if err != nil {
+		return nil
+	}
+	var s []prompt.Suggest
+	for _, key := range c.completeKeys(prefix) {
+		text := encodeLine(key, c.opts.KeyEncoding)
+		if c.opts.KeyEncoding == "escape" {
+			// the line is split on spaces
+			text = strings.Replace(text, " ", `\x20`, -1)
+		}
+		s = append(s, prompt.Suggest{Text: text})
+	}
+	return s
+}
+
+// completeKeys returns at most completeLimit keys with the prefix, from the
+// cache if it is fresh enough. The keys are not scanned in an explicit
+// transaction, an abandoned scan would still be using it.
+func (c *command) completeKeys(prefix []byte) [][]byte {
+	if c.cli.InTxn() {
+		return nil
+	}
+	keyspace := string(c.cli.Keyspace())
+	if kc := c.completion; kc != nil && kc.keyspace == keyspace && time.Since(kc.at) < completeTTL {
+		// the keys of a longer prefix are among the ones of a complete result
+		if bytes.Equal(kc.prefix, prefix) || kc.complete && bytes.HasPrefix(prefix, kc.prefix) {
+			var keys [][]byte
+			for _, key := range kc.keys {
+				if bytes.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			return keys
+		}
+	}
+
+	// the diagnostics of --verbose and the logs would garble the prompt
+	c.cli.SetTimeout(completeTimeout)
+	c.cli.SetVerbose(false)
+	tikvclient.SetLogLevel(tikvclient.LogOff)
+	defer c.applyOpts()
+	begin := prefix
+	if len(begin) == 0 {
+		begin = []byte{0}
+	}
+	var keys [][]byte
+	_, err := c.cli.Scan(begin, completeLimit+1, nil, func(key, val []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		keys = append(keys, append([]byte{}, key...))
+		return true
+	})
+	complete := len(keys) <= completeLimit
+	if !complete {
+		keys = keys[:completeLimit]
+	}
+	// a failure is cached too, so an unreachable cluster is not retried on
+	// every keystroke
+	if err != nil {
+		keys, complete = nil, false
+	}
+	c.completion = &keyCompletion{keyspace: keyspace, prefix: prefix, keys: keys, complete: complete, at: time.Now()}
+	return keys
+}
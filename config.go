@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+)
+
+// urlEnv is the environment variable holding the url if --url is not given
+const urlEnv = "TIKV_URL"
+
+// configPath returns the default config file in the home directory
+func configPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".tikv-cli.toml")
+}
+
+// applyConfig sets the global flags which are not given on the command line
+// from TIKV_URL and the config file, the environment takes precedence over
+// the file. The file holds the flags by their names, like
+//
+//	url = "tikv://pd1:2379"
+//	output = "json"
+//
+// A missing file is ignored unless it was given explicitly by --config.
+func applyConfig(fs *pflag.FlagSet, path string, explicit bool) error {
+	if path != "" {
+		var values map[string]interface{}
+		if _, err := toml.DecodeFile(path, &values); err != nil {
+			if !os.IsNotExist(err) || explicit {
+				return fmt.Errorf("config %s: %v", path, err)
+			}
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f := fs.Lookup(name)
+			if f == nil || name == "config" {
+				return fmt.Errorf("config %s: unknown option %q", path, name)
+			}
+			if f.Changed {
+				continue
+			}
+			if err := f.Value.Set(fmt.Sprint(values[name])); err != nil {
+				return fmt.Errorf("config %s: invalid value of %s: %v", path, name, err)
+			}
+		}
+	}
+	if url := os.Getenv(urlEnv); url != "" && !fs.Changed("url") {
+		return fs.Set("url", url)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// printDiagnoses prints a readable report, or one JSON object per check
+func printDiagnoses(ds []tikvclient.Diagnosis, opts *Options) {
+	if opts.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		if opts.JSONPretty {
+			enc.SetIndent("", "  ")
+		}
+		for _, d := range ds {
+			enc.Encode(d)
+		}
+		return
+	}
+	for _, d := range ds {
+		fmt.Printf("[%s] %-10s %s\n", d.Status, d.Check, d.Detail)
+		if d.Hint != "" {
+			fmt.Printf("       %-10s %s\n", "", d.Hint)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// printError prints the error to stderr as text or as a JSON object with its
+// code according to --format-error, the code becomes the exit status of the
+// command line
+func (c *command) printError(err error) {
+	c.failures++
+	if isBrokenPipe(err) {
+		// stdout is closed, like the end of head, so there is nowhere to
+		// print and the command exits like a process killed by SIGPIPE
+		if c.exitCode == 0 {
+			c.exitCode = exitBrokenPipe
+		}
+		return
+	}
+	code := tikvclient.CodeOf(err)
+	if c.exitCode == 0 {
+		c.exitCode = code.ExitCode()
+	}
+	if c.opts.FormatError != "json" {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	data, _ := json.Marshal(struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}{err.Error(), code.String()})
+	fmt.Fprintln(os.Stderr, string(data))
+}
+
+// exitBrokenPipe is the exit status after stdout was closed, the one a shell
+// reports for a process killed by SIGPIPE
+const exitBrokenPipe = 128 + 13
+
+// isBrokenPipe reports whether the error is a write to a closed pipe. SIGPIPE
+// is ignored, so the writes to a closed stdout fail with EPIPE instead of
+// killing the process before it cleans up.
+func isBrokenPipe(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	return err == syscall.EPIPE
+}
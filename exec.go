@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// execPipe transforms values by piping each of them into an external command
+// and emitting its stdout instead. At most n commands run at the same time and
+// the results are emitted in the order they were submitted.
+type execPipe struct {
+	command string
+	emit    func(key, val []byte) error
+
+	sem   chan struct{}
+	queue chan *execJob
+	wg    sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // the first failure of emit
+}
+
+type execJob struct {
+	key   []byte
+	out   []byte
+	err   error
+	ready chan struct{}
+}
+
+func newExecPipe(command string, n int, emit func(key, val []byte) error) *execPipe {
+	p := &execPipe{
+		command: command,
+		emit:    emit,
+		sem:     make(chan struct{}, n),
+		queue:   make(chan *execJob, n),
+	}
+	p.wg.Add(1)
+	go p.collect()
+	return p
+}
+
+// submit runs the command for the value, it blocks if n commands are running
+// and returns false once emitting has failed
+func (p *execPipe) submit(key, val []byte) bool {
+	if p.failed() != nil {
+		return false
+	}
+	job := &execJob{key: append([]byte{}, key...), ready: make(chan struct{})}
+	val = append([]byte{}, val...)
+	p.sem <- struct{}{}
+	p.queue <- job
+	go func() {
+		defer func() { <-p.sem }()
+		job.out, job.err = p.run(val)
+		close(job.ready)
+	}()
+	return true
+}
+
+func (p *execPipe) run(val []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", p.command)
+	cmd.Stdin = bytes.NewReader(val)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// collect emits the results in order, a failed command only skips its key
+func (p *execPipe) collect() {
+	defer p.wg.Done()
+	for job := range p.queue {
+		<-job.ready
+		if job.err != nil {
+			fmt.Fprintf(os.Stderr, "exec failed for key %s: %v\n", tikvclient.DisplayKey(job.key), job.err)
+			continue
+		}
+		if p.failed() != nil {
+			continue
+		}
+		if err := p.emit(job.key, job.out); err != nil {
+			p.mu.Lock()
+			p.err = err
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *execPipe) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// close waits for the submitted commands and returns the first failure of emit
+func (p *execPipe) close() error {
+	close(p.queue)
+	p.wg.Wait()
+	return p.failed()
+}
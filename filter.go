@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// scanFilter decides whether a scanned pair is emitted, unlike the bounds of
+// a scan a filtered pair does not stop it but an error does
+type scanFilter func(key, val []byte) (bool, error)
+
+// applyFilters reports whether the pair passes all the filters
+func applyFilters(filters []scanFilter, key, val []byte) (bool, error) {
+	for _, f := range filters {
+		ok, err := f(key, val)
+		if !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// containsFilter passes the pairs whose key or value contains the literal
+// substring, it is cheaper than matching a pattern
+func containsFilter(substr []byte, value, ignoreCase bool) scanFilter {
+	if ignoreCase {
+		substr = bytes.ToLower(substr)
+	}
+	return func(key, val []byte) (bool, error) {
+		data := key
+		if value {
+			data = val
+		}
+		if ignoreCase {
+			data = bytes.ToLower(data)
+		}
+		return bytes.Contains(data, substr), nil
+	}
+}
+
+// matchFilter passes the pairs whose key matches the regexp, the key is
+// matched as a string of its raw bytes
+func matchFilter(re *regexp.Regexp) scanFilter {
+	return func(key, val []byte) (bool, error) {
+		return re.Match(key), nil
+	}
+}
+
+// sampler passes every pair with the probability rate, a zero seed is
+// replaced by the clock
+type sampler struct {
+	mu   sync.Mutex
+	rate float64
+	rnd  *rand.Rand
+}
+
+func newSampler(rate float64, seed int64) (*sampler, error) {
+	if rate <= 0 || rate > 1 {
+		return nil, fmt.Errorf("invalid --sample-rate %v, should be in (0, 1]", rate)
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &sampler{rate: rate, rnd: rand.New(rand.NewSource(seed))}, nil
+}
+
+func (s *sampler) filter(key, val []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < s.rate, nil
+}
+
+// dedup passes only the first pair of every distinct value, or key if keys is
+// set. It remembers the sha1 of at most limit distinct ones.
+type dedup struct {
+	mu    sync.Mutex
+	keys  bool
+	limit int
+	seen  map[[sha1.Size]byte]struct{}
+}
+
+func newDedup(keys bool, limit int) *dedup {
+	return &dedup{keys: keys, limit: limit, seen: make(map[[sha1.Size]byte]struct{})}
+}
+
+func (d *dedup) filter(key, val []byte) (bool, error) {
+	data := val
+	if d.keys {
+		data = key
+	}
+	sum := sha1.Sum(data)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.seen[sum]; ok {
+		return false, nil
+	}
+	if len(d.seen) >= d.limit {
+		return false, fmt.Errorf("more than %d distinct ones, increase --dedup-limit", d.limit)
+	}
+	d.seen[sum] = struct{}{}
+	return true, nil
+}
+
+// distinct returns the number of distinct values or keys seen
+func (d *dedup) distinct() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.seen)
+}
+
+// keySet passes only the keys of the set
+type keySet map[string]struct{}
+
+// loadKeySet loads a set of keys from a file with one key per line
+func loadKeySet(path, encoding string) (keySet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(keySet)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		key, err := decodeArg(scanner.Text(), encoding)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		keys[string(key)] = struct{}{}
+	}
+	return keys, scanner.Err()
+}
+
+// filter is safe for concurrent use because the set is never written after loading
+func (ks keySet) filter(key, val []byte) (bool, error) {
+	_, ok := ks[string(key)]
+	return ok, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// maxHistory is the number of lines loaded from the history file
+const maxHistory = 1000
+
+// history is the line history of the shell, every line is appended to the
+// file as soon as it is executed so nothing is lost if the shell is killed
+type history struct {
+	lines []string
+	f     *os.File // nil if the history is not persisted
+}
+
+// historyPath returns the default history file in the home directory
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".tikv-cli_history")
+}
+
+// loadHistory reads the last lines of the file and opens it for appending, the
+// file is created if it does not exist. The history is kept in memory only if
+// the file can not be used, an empty path disables the persistence.
+func loadHistory(path string) *history {
+	h := &history{}
+	if path == "" {
+		return h
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: the history is not saved:", err)
+		return h
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if scanner.Text() != "" {
+			h.lines = append(h.lines, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: failed to read the history:", err)
+	}
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[len(h.lines)-maxHistory:]
+	}
+	h.f = f
+	return h
+}
+
+// add records an executed line
+func (h *history) add(line string) {
+	if line == "" {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if h.f == nil {
+		return
+	}
+	if _, err := fmt.Fprintln(h.f, line); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: the history is not saved:", err)
+		h.f.Close()
+		h.f = nil
+	}
+}
+
+func (h *history) close() {
+	if h.f != nil {
+		h.f.Close()
+		h.f = nil
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// commitHook runs a shell command after every successful mutation, the key
+// and the operation are passed by environment variables. Unless sync is set
+// the commands run in the background one at a time, at most queue of them
+// are pending before the mutations block.
+type commitHook struct {
+	command string
+	sync    bool
+
+	queue chan []string
+	wg    sync.WaitGroup
+}
+
+func newCommitHook(command string, sync bool, queue int) *commitHook {
+	h := &commitHook{command: command, sync: sync}
+	if !sync {
+		h.queue = make(chan []string, queue)
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			for env := range h.queue {
+				h.run(env)
+			}
+		}()
+	}
+	return h
+}
+
+// hookEvent is a mutation waiting for its commit hook
+type hookEvent struct {
+	op  string
+	key []byte
+}
+
+// fire runs the hook for the operation on the key
+func (h *commitHook) fire(op string, key []byte) {
+	env := []string{"TIKV_OP=" + op, "TIKV_KEY_HEX=" + hex.EncodeToString(key)}
+	// environment variables can not hold NUL bytes
+	if bytes.IndexByte(key, 0) < 0 {
+		env = append(env, "TIKV_KEY="+string(key))
+	}
+	if h.sync {
+		h.run(env)
+		return
+	}
+	h.queue <- env
+}
+
+func (h *commitHook) run(env []string) {
+	cmd := exec.Command("sh", "-c", h.command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "commit hook failed with %s: %v %s\n", strings.Join(env[:2], " "), err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+// wait waits for the pending hooks to finish, the hook can not be fired after it
+func (h *commitHook) wait() {
+	if h.sync {
+		return
+	}
+	close(h.queue)
+	h.wg.Wait()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// info prints the fields of Info as name: value lines, or as a JSON object
+// with --output json
+func (c *command) info(args []string) {
+	fields, err := c.cli.Info()
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	fields = append([]tikvclient.InfoField{{Name: "version", Value: version}}, fields...)
+	if c.opts.Output == "json" {
+		obj := make(map[string]string, len(fields))
+		for _, f := range fields {
+			obj[f.Name] = f.Value
+		}
+		out, _ := json.Marshal(obj)
+		fmt.Println(string(out))
+		return
+	}
+	for _, f := range fields {
+		fmt.Printf("%s: %s\n", f.Name, f.Value)
+	}
+}
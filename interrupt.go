@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// interruptWindow is how soon a second Ctrl-C exits the shell
+const interruptWindow = 2 * time.Second
+
+// runInterruptible runs a line of the shell with a context canceled by
+// Ctrl-C, so a runaway command returns to the prompt, and a second Ctrl-C
+// within interruptWindow exits. The handler only lives as long as the line,
+// Ctrl-C at the prompt is still handled by the prompt.
+func (c *command) runInterruptible(line string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.cli.SetContext(ctx)
+	defer c.cli.SetContext(nil)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		var first time.Time
+		for {
+			select {
+			case <-done:
+				return
+			case <-interrupt:
+			}
+			if !first.IsZero() && time.Since(first) < interruptWindow {
+				fmt.Fprintln(os.Stderr, "interrupted twice, exiting")
+				os.Exit(130)
+			}
+			first = time.Now()
+			fmt.Fprintln(os.Stderr, "canceling, press Ctrl-C again to exit")
+			cancel()
+		}
+	}()
+	runLine(c, line)
+}
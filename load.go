@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// loadCheckpoint is the progress of a load, it is saved after every
+// committed batch so a rerun resumes after the last committed line
+type loadCheckpoint struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"` // byte offset of the first line not committed
+	Line   int64  `json:"line"`   // number of lines committed
+}
+
+// readCheckpoint reads the checkpoint of the file, a missing checkpoint
+// starts from the beginning
+func readCheckpoint(path, file string) (*loadCheckpoint, error) {
+	cp := &loadCheckpoint{File: file}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %v", path, err)
+	}
+	if cp.File != file {
+		return nil, fmt.Errorf("the checkpoint %s belongs to %s, remove it to load %s", path, cp.File, file)
+	}
+	return cp, nil
+}
+
+// write replaces the checkpoint file by renaming a temporary one, so a crash
+// leaves either the old or the new checkpoint
+func (cp *loadCheckpoint) write(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// jsonLinesReader reads the pairs of the JSON lines written by scan --output
+// json, one object per line, and tracks the position of the lines read
+type jsonLinesReader struct {
+	r      *bufio.Reader
+	offset int64 // byte offset after the last line read
+	line   int64 // number of lines read
+}
+
+func newJSONLinesReader(r io.Reader, offset, line int64) *jsonLinesReader {
+	return &jsonLinesReader{r: bufio.NewReader(r), offset: offset, line: line}
+}
+
+// next returns the next pair, blank lines are skipped and io.EOF is returned
+// after the last one
+func (jr *jsonLinesReader) next() (*kvPair, error) {
+	for {
+		data, err := jr.r.ReadBytes('\n')
+		if err == io.EOF && len(data) == 0 {
+			return nil, io.EOF
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		jr.offset += int64(len(data))
+		jr.line++
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		p := &kvPair{}
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, fmt.Errorf("line %d: %v", jr.line, err)
+		}
+		return p, nil
+	}
+}
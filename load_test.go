@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap/tidb/kv"
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// crashStore counts the committed writes of every key and fails all the
+// commits once commits reached 0, like a process killed meanwhile
+type crashStore struct {
+	tikvclient.Store
+	commits int // number of the commits before the crash, -1 for no crash
+	writes  map[string]int
+}
+
+func (s *crashStore) Begin() (kv.Transaction, error) {
+	txn, err := s.Store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &crashTxn{Transaction: txn, store: s}, nil
+}
+
+type crashTxn struct {
+	kv.Transaction
+	store *crashStore
+	keys  []string
+}
+
+func (txn *crashTxn) Set(k kv.Key, v []byte) error {
+	txn.keys = append(txn.keys, string(k))
+	return txn.Transaction.Set(k, v)
+}
+
+func (txn *crashTxn) Commit(ctx context.Context) error {
+	if txn.store.commits == 0 {
+		txn.Transaction.Rollback()
+		return errors.New("killed")
+	}
+	if err := txn.Transaction.Commit(ctx); err != nil {
+		return err
+	}
+	txn.store.commits--
+	for _, k := range txn.keys {
+		txn.store.writes[k]++
+	}
+	return nil
+}
+
+func TestLoadResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tikv-cli-load")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pairs := filepath.Join(dir, "pairs.json")
+	checkpoint := filepath.Join(dir, "load.ckpt")
+	f, err := os.Create(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			f.WriteString("\n")
+		}
+		if err := enc.Encode(&kvPair{Key: []byte(fmt.Sprintf("k%d", i)), Value: []byte("v")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	store := &crashStore{Store: tikvclient.NewMemStore(), commits: 2, writes: make(map[string]int)}
+	c := newTestCommand(t)
+	c.cli = tikvclient.NewClient(store)
+	mustFail(t, c, fmt.Sprintf("load %s --batch 3 --checkpoint %s", pairs, checkpoint))
+	if len(store.writes) != 6 {
+		t.Fatalf("%d keys were written before the crash, want 6", len(store.writes))
+	}
+
+	// a new process resumes after the last committed batch
+	store.commits = -1
+	c = newTestCommand(t)
+	c.cli = tikvclient.NewClient(store)
+	output(t, c, fmt.Sprintf("load %s --batch 3 --checkpoint %s", pairs, checkpoint))
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if n := store.writes[key]; n != 1 {
+			t.Errorf("%s was written %d times", key, n)
+		}
+	}
+}
@@ -16,99 +16,2114 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/c-bata/go-prompt"
+	"github.com/pingcap/tidb/kv"
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type Options struct {
-	Url string
+	Url         string
+	Output      string // output format, text, json, csv, tsv or table
+	JSONPretty  bool   // emit a pretty printed JSON array
+	JSONCompact bool   // emit newline delimited JSON objects
+	KeySplit    string // split composite keys into columns by this separator
+	KeyColumns  int    // number of key columns when splitting
+	DB          int    // logical database, -1 means the raw keyspace
+
+	Template     string // text/template rendering each key/value pair
+	TemplateFile string // file to load the template from
+
+	ConfirmThreshold int64 // confirm destructive operations affecting more keys, -1 to disable
+	PrecountCap      int64 // max number of keys counted before a destructive operation
+	SkipPrecount     bool  // always confirm instead of counting the affected keys
+
+	CommitHook     string // shell command run after every successful set or delete
+	CommitHookSync bool   // wait for the commit hook before returning
+
+	KeyEncoding string // encoding of the keys and values given as arguments
+	MetaFields  string // comma separated fields of ndjson-with-meta
+
+	RegionErrorRetries int    // extra attempts of an operation failed with a region error
+	MaxConcurrentTxns  int    // max number of transactions open at the same time
+	Verbose            bool   // print diagnostics to stderr
+	HexKeysInErrors    bool   // render the keys of error messages as hex
+	ConnectMode        string // txn, raw or auto
+	FormatError        string // text or json
+	NoHistory          bool   // do not persist the shell history
+	Config             string // file of the default values of the options
+
+	Timeout time.Duration // bound of every request to the cluster, 0 for none
+	NoColor bool          // never colorize the text output of a terminal
+
+	ConnectRetries int           // extra connection attempts at startup
+	ConnectBackoff time.Duration // wait before the first retry, doubled after every one
+
+	MaxRetries int // extra attempts of a mutation failed with a write conflict
+
+	MetricsAddr string // address serving the operation metrics, empty for none
+
+	Namespace string // prefix of all the keys within the db, in the key encoding
+
+	LogLevel string // level of the logs of the TiKV client, empty for off or warn with --verbose
+
+	BinaryCells string // encoding of the binary cells of csv and tsv, hex, base64 or raw
+
+	tmpl       *template.Template
+	metaFields map[string]bool
+	namespace  []byte
+}
+
+// validate checks the combination of the global options
+func (opts *Options) validate() error {
+	switch opts.Output {
+	case "text", "json", "csv", "tsv", "table", "ndjson-with-meta", "hex", "base64", "raw":
+	default:
+		return fmt.Errorf("unknown output format %q, should be text, json, ndjson-with-meta, csv, tsv, table, hex, base64 or raw", opts.Output)
+	}
+	opts.metaFields = make(map[string]bool)
+	for _, field := range strings.Split(opts.MetaFields, ",") {
+		field = strings.TrimSpace(field)
+		known := false
+		for _, f := range metaFields {
+			known = known || f == field
+		}
+		if !known {
+			return fmt.Errorf("unknown meta field %q, should be one of %s", field, strings.Join(metaFields, ","))
+		}
+		opts.metaFields[field] = true
+	}
+	if opts.JSONPretty && opts.JSONCompact {
+		return fmt.Errorf("--json-pretty and --json-compact are mutually exclusive")
+	}
+	switch opts.KeyEncoding {
+	case "escape", "hex", "base64":
+	default:
+		return fmt.Errorf("unknown key encoding %q, should be escape, hex or base64", opts.KeyEncoding)
+	}
+	switch opts.FormatError {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unknown error format %q, should be text or json", opts.FormatError)
+	}
+	switch opts.ConnectMode {
+	case tikvclient.ModeTxn, tikvclient.ModeRaw, tikvclient.ModeAuto:
+	default:
+		return fmt.Errorf("unknown connect mode %q, should be txn, raw or auto", opts.ConnectMode)
+	}
+	if opts.ConnectRetries < 0 || opts.ConnectBackoff < 0 {
+		return fmt.Errorf("--connect-retries and --connect-backoff can not be negative")
+	}
+	if opts.MaxRetries < 0 {
+		return fmt.Errorf("--max-retries can not be negative")
+	}
+	switch opts.LogLevel {
+	case "", tikvclient.LogOff, "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("unknown log level %q, should be debug, info, warn, error or off", opts.LogLevel)
+	}
+	switch opts.BinaryCells {
+	case "hex", "base64", "raw":
+	default:
+		return fmt.Errorf("unknown binary cell encoding %q, should be hex, base64 or raw", opts.BinaryCells)
+	}
+	// the namespace is decoded like a key, so it also follows --key-encoding
+	namespace, err := decodeArg(opts.Namespace, opts.KeyEncoding)
+	if err != nil {
+		return fmt.Errorf("invalid --namespace %q: %v", opts.Namespace, err)
+	}
+	opts.namespace = namespace
+	if opts.Template != "" && opts.TemplateFile != "" {
+		return fmt.Errorf("--template and --template-file are mutually exclusive")
+	}
+	text := opts.Template
+	if opts.TemplateFile != "" {
+		data, err := ioutil.ReadFile(opts.TemplateFile)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	}
+	if text != "" {
+		if opts.Output != "text" {
+			return fmt.Errorf("templates can only be used with --output text")
+		}
+		tmpl, err := parseTemplate(text)
+		if err != nil {
+			return err
+		}
+		opts.tmpl = tmpl
+	}
+	return nil
+}
+
+// logLevel returns the level of the logs of the TiKV client, --verbose shows
+// the warnings unless --log-level is given
+func (opts *Options) logLevel() string {
+	switch {
+	case opts.LogLevel != "":
+		return opts.LogLevel
+	case opts.Verbose:
+		return "warn"
+	}
+	return tikvclient.LogOff
+}
+
+// plain reports whether the results are printed in the default quoted format
+func (opts *Options) plain() bool {
+	return opts.Output == "text" && opts.tmpl == nil
+}
+
+type command struct {
+	cli  *tikvclient.TikvClient
+	opts *Options
+	hook *commitHook // nil if there is no --commit-hook
+	undo *undoRecord // the before image of the last set or delete
+
+	history    *history       // the line history of the shell, nil out of the shell
+	completion *keyCompletion // the keys suggested last by the completer
+
+	// pendingHooks are the commit hooks of the explicit transaction, fired
+	// once it is committed
+	pendingHooks []hookEvent
+
+	// globalFlags are the flags of the Options, changed in the shell by set-opt
+	globalFlags *pflag.FlagSet
+
+	// exitCode is the exit status of the command line, set by the first error
+	exitCode int
+
+	// failures counts the errors printed, a statement failed if it changed
+	failures    int
+	sourceDepth int // nesting of the running source commands
+
+	scanOpts scanOptions
+
+	// inputOpts override the --key-encoding for a single command
+	inputOpts struct {
+		hex    bool
+		base64 bool
+	}
+
+	// stdinOpts read the keys of get and delete from stdin
+	stdinOpts struct {
+		stdin bool // read the keys from stdin, like a - argument
+		batch int  // number of keys of a transaction
+	}
+
+	// valueType is the --type of the values of get and set
+	valueType string
+	// preview is the number of bytes of the values displayed by get
+	preview int
+	// getOut is the file get writes the raw value to, - for stdout
+	getOut string
+	// getPrefix makes get print the pairs with this prefix, getLimit caps them
+	getPrefix string
+	getLimit  int64
+	// snapshotTS is the --snapshot timestamp of get and scan, 0 for the latest
+	snapshotTS uint64
+
+	setOpts struct {
+		noWarn        bool // do not warn about large keys and values
+		warnKeySize   int  // key size warned about
+		warnValueSize int  // value size warned about
+		verify        bool // read the value back after the commit
+
+		valueFile string // file holding the value, - for stdin
+	}
+
+	renameOpts struct {
+		prefix    bool // rename all the keys under the prefix
+		overwrite bool // replace the existing targets
+	}
+
+	copyOpts struct {
+		overwrite bool // replace the existing target
+	}
+
+	flushOpts struct {
+		yes   bool // skip the confirmation
+		batch int  // number of keys deleted in one transaction
+	}
+
+	deleteOpts struct {
+		prefix string // delete the keys with this prefix instead of the arguments
+		yes    bool   // confirm the deletion of the prefix
+	}
+
+	truncateOpts struct {
+		prefix        string // the prefix of the deleted keys
+		yes           bool   // confirm the deletion, it is required
+		batch         int    // number of keys deleted in one transaction
+		wholeKeyspace bool   // confirm the deletion of every key for an empty prefix
+	}
+
+	existsOpts struct {
+		prefix      string // the prefix scanned once
+		againstFile string // file of the candidate keys
+		list        bool   // list the present and absent keys
+	}
+
+	sourceOpts struct {
+		continueOnError bool // run the rest of the script after a failing line
+	}
+
+	benchOpts struct {
+		ops         string // comma separated phases, set, get or scan
+		keys        int    // number of the random keys
+		concurrency int    // number of the goroutines
+		valueSize   int    // size of the random values
+		keep        bool   // do not delete the keys at the end
+	}
+
+	watchOpts struct {
+		prefix   string        // watch the keys with the prefix
+		interval time.Duration // time between the polls
+		limit    int           // max number of keys of a prefix watch
+	}
+
+	dumpOpts struct {
+		prefix bool   // dump the keys with the prefix
+		until  string // dump until this key, inclusive
+		out    string // file the pairs are written to
+	}
+
+	restoreOpts struct {
+		in string // file written by dump
+	}
+
+	countOpts struct {
+		limit  int64  // stop counting at this many keys
+		prefix bool   // count the keys with the prefix
+		until  string // count until this key, inclusive
+	}
+
+	loadOpts struct {
+		batch      int    // number of pairs written in one transaction
+		checkpoint string // file recording the progress after every batch
+	}
+}
+
+func (c *command) get(args []string) {
+	if c.getPrefix != "" {
+		c.getByPrefix(args)
+		return
+	}
+	if c.getLimit >= 0 {
+		c.printError(fmt.Errorf("--limit can only be used with --prefix"))
+		return
+	}
+	if len(args) == 0 {
+		c.printError(fmt.Errorf("key is required"))
+		return
+	}
+	c.cli.SetSnapshot(c.snapshotTS)
+	defer c.cli.SetSnapshot(0)
+	if c.getOut != "" {
+		c.getToFile(args)
+		return
+	}
+	var w outputWriter
+	if !c.opts.plain() {
+		w = c.newOutputWriter(os.Stdout)
+		defer w.Flush()
+	}
+	if c.fromStdin(args) {
+		var total int64
+		n, err := c.readStdinKeys(func(keys [][]byte) error {
+			if !c.getKeys(keys, w) {
+				return errStdinStopped
+			}
+			total += int64(len(keys))
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "stopped at line %d\n", n)
+			if err != errStdinStopped {
+				c.printError(err)
+			}
+		}
+		fmt.Fprintln(os.Stderr, "Total read", total)
+		return
+	}
+	keys, err := c.decodeArgs(args)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	c.getKeys(keys, w)
+}
+
+// getToFile writes the raw value of a single key to the --out file, a missing
+// key creates no file
+func (c *command) getToFile(args []string) {
+	if len(args) != 1 || c.fromStdin(args) {
+		c.printError(fmt.Errorf("--out writes the value of exactly one key"))
+		return
+	}
+	if c.preview > 0 {
+		c.printError(fmt.Errorf("--preview can not be used with --out"))
+		return
+	}
+	keys, err := c.decodeArgs(args)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	val, err := c.cli.Get(keys[0])
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	if c.getOut == "-" {
+		if _, err := os.Stdout.Write(val); err != nil {
+			c.printError(err)
+		}
+		return
+	}
+	if err := ioutil.WriteFile(c.getOut, val, 0644); err != nil {
+		c.printError(err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes to %s\n", len(val), c.getOut)
+}
+
+// getKeys prints the values of the keys, it returns false if they could not
+// be read
+func (c *command) getKeys(keys [][]byte, w outputWriter) bool {
+	// all the keys are read from the same snapshot, and there is exactly one
+	// result per key in the requested order. A missing key is a nil value
+	// and does not stop the others, only a failed read does.
+	vals, err := c.cli.GetMany(keys)
+	if err != nil {
+		c.printError(err)
+		return false
+	}
+	for i, val := range vals {
+		if val == nil {
+			// scripts tell a missing key by the exit status, like exists
+			if c.exitCode == 0 {
+				c.exitCode = tikvclient.ErrNotFound.ExitCode()
+			}
+			continue
+		}
+		if vals[i], err = typedValue(val, c.valueType); err != nil {
+			c.printError(fmt.Errorf("%s: %v", tikvclient.DisplayKey(keys[i]), err))
+			return false
+		}
+	}
+	color := w == nil && colorEnabled(os.Stdout, c.opts)
+	for i := range keys {
+		// the client always reads whole values, only the display is truncated
+		val, truncated := vals[i], 0
+		if c.preview > 0 && len(val) > c.preview {
+			val, truncated = val[:c.preview], len(val)
+		}
+		if w != nil {
+			w.Write(keys[i], val)
+			if truncated > 0 {
+				fmt.Fprintf(os.Stderr, "%s: showing %d of %d bytes\n", tikvclient.DisplayKey(keys[i]), c.preview, truncated)
+			}
+			continue
+		}
+		fmt.Println(paint(color, keyColor, strconv.Quote(string(keys[i]))))
+		if val == nil {
+			fmt.Println(paint(color, missingColor, missing))
+			continue
+		}
+		fmt.Println(paint(color, valueColor, strconv.Quote(string(val))))
+		if truncated > 0 {
+			fmt.Printf("(showing %d of %d bytes)\n", c.preview, truncated)
+		}
+	}
+	return true
+}
+func (c *command) set(args []string) {
+	var pair [][]byte
+	if c.setOpts.valueFile != "" {
+		val, err := c.readValueFile(args)
+		if err != nil {
+			c.printError(err)
+			return
+		}
+		if pair, err = c.decodeArgs(args); err != nil {
+			c.printError(err)
+			return
+		}
+		pair = append(pair, val)
+	} else {
+		if len(args) != 2 {
+			c.printError(fmt.Errorf("key and value are required"))
+			return
+		}
+		var err error
+		if pair, err = c.decodeArgs(args); err != nil {
+			c.printError(err)
+			return
+		}
+		if pair[1], err = typedValue(pair[1], c.valueType); err != nil {
+			c.printError(err)
+			return
+		}
+	}
+	if !c.setOpts.noWarn {
+		for _, warning := range sizeWarnings(pair[0], pair[1], c.setOpts.warnKeySize, c.setOpts.warnValueSize) {
+			fmt.Fprintln(os.Stderr, "warning:", warning)
+		}
+	}
+	c.cli.SetVerify(c.setOpts.verify)
+	old, err := c.cli.GetSet(pair[0], pair[1])
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	c.undo = &undoRecord{op: "set", pairs: []kvPair{{Key: pair[0], Value: old}}}
+	c.fireHook("set", pair[0])
+}
+
+// readValueFile reads the value of set --value-file verbatim, - reads stdin
+func (c *command) readValueFile(args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("only the key is given with --value-file")
+	}
+	if c.valueType != "string" {
+		return nil, fmt.Errorf("--type can not be used with --value-file, the file is stored verbatim")
+	}
+	if c.setOpts.valueFile == "-" {
+		// the shell reads its lines from stdin
+		if c.history != nil {
+			return nil, fmt.Errorf("--value-file - can not be used in the shell")
+		}
+		return ioutil.ReadAll(os.Stdin)
+	}
+	val, err := ioutil.ReadFile(c.setOpts.valueFile)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("value file %s does not exist", c.setOpts.valueFile)
+	}
+	return val, err
+}
+
+func (c *command) incr(args []string) {
+	c.addInt(args, 1)
+}
+
+func (c *command) decr(args []string) {
+	c.addInt(args, -1)
+}
+
+// addInt adds the optional amount, 1 by default, times sign to the integer
+// value of the key
+func (c *command) addInt(args []string, sign int64) {
+	if len(args) != 1 && len(args) != 2 {
+		c.printError(fmt.Errorf("key is required"))
+		return
+	}
+	delta := int64(1)
+	if len(args) == 2 {
+		var err error
+		if delta, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			c.printError(fmt.Errorf("invalid amount %q", args[1]))
+			return
+		}
+	}
+	keys, err := c.decodeArgs(args[:1])
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	n, err := c.cli.Incr(keys[0], sign*delta)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	fmt.Printf("(integer) %d\n", n)
+	// the previous value is unknown for a missing key, so it can not be undone
+	c.undo = nil
+	c.fireHook("set", keys[0])
+}
+
+// mset writes the key value pairs in one transaction
+func (c *command) mset(args []string) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		c.printError(fmt.Errorf("pairs of key and value are required"))
+		return
+	}
+	decoded, err := c.decodeArgs(args)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	pairs := make([]kvPair, 0, len(decoded)/2)
+	for i := 0; i < len(decoded); i += 2 {
+		val, err := typedValue(decoded[i+1], c.valueType)
+		if err != nil {
+			c.printError(err)
+			return
+		}
+		pairs = append(pairs, kvPair{Key: decoded[i], Value: val})
+	}
+	c.undo = nil
+	if err := c.cli.SetMany(pairs); err != nil {
+		c.printError(err)
+		return
+	}
+	for _, p := range pairs {
+		c.fireHook("set", p.Key)
+	}
+}
+
+// sizeWarnings returns the warnings about a key or value reaching the size
+// thresholds, such pairs make hotspots and large regions in TiKV
+func sizeWarnings(key, val []byte, keySize, valueSize int) []string {
+	var warnings []string
+	if len(key) > keySize {
+		warnings = append(warnings, fmt.Sprintf("the key is %d bytes, keys over %d bytes slow down TiKV", len(key), keySize))
+	}
+	if len(val) > valueSize {
+		warnings = append(warnings, fmt.Sprintf("the value is %d bytes, values over %d bytes make hotspots and large regions", len(val), valueSize))
+	}
+	return warnings
+}
+
+func (c *command) getFlags(fs *pflag.FlagSet) {
+	c.valueFlags(fs)
+	c.stdinFlags(fs)
+	c.snapshotFlags(fs)
+	fs.IntVar(&c.preview, "preview", 0, "display only the first N bytes of every value and its total size")
+	fs.StringVar(&c.getOut, "out", "", "write the raw bytes of the value of a single key to this file, - for stdout")
+	fs.StringVar(&c.getPrefix, "prefix", "", "print the pairs with this prefix instead of the given keys, like scan <prefix> --prefix")
+	fs.Int64VarP(&c.getLimit, "limit", "n", -1, "max number of the pairs printed by --prefix")
+}
+
+// getByPrefix prints the pairs with the --prefix of get by running the scan
+// of the prefix with the default scan options and the --limit of get
+func (c *command) getByPrefix(args []string) {
+	if len(args) > 0 || c.stdinOpts.stdin {
+		c.printError(fmt.Errorf("get takes keys or --prefix, not both"))
+		return
+	}
+	if c.getOut != "" || c.preview > 0 {
+		c.printError(fmt.Errorf("--out and --preview can not be used with --prefix"))
+		return
+	}
+	prefix, err := c.decodeArgs([]string{c.getPrefix})
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	// the options of a previous scan are reset, the --snapshot of get is kept
+	c.scanOpts = defaultScanOptions()
+	c.scanOpts.prefix = true
+	c.scanOpts.limit = c.getLimit
+	// scan decodes its argument with --key-encoding, and get with its --input-*
+	c.scan([]string{encodeLine(prefix[0], c.opts.KeyEncoding)})
+}
+
+func (c *command) snapshotFlags(fs *pflag.FlagSet) {
+	fs.Uint64Var(&c.snapshotTS, "snapshot", 0, "read the data as of this TSO timestamp, it has to be newer than the GC safe point")
+}
+
+func (c *command) setFlags(fs *pflag.FlagSet) {
+	c.valueFlags(fs)
+	fs.BoolVar(&c.setOpts.noWarn, "no-warn", false, "do not warn about large keys and values")
+	fs.IntVar(&c.setOpts.warnKeySize, "warn-key-size", 1024, "warn if the key has more than this many bytes")
+	fs.IntVar(&c.setOpts.warnValueSize, "warn-value-size", 1<<20, "warn if the value has more than this many bytes")
+	fs.BoolVar(&c.setOpts.verify, "verify", false, "read the value back after the commit and fail if it differs")
+	fs.StringVar(&c.setOpts.valueFile, "value-file", "", "read the value verbatim from this file instead of the argument, - for stdin")
+}
+
+// fireHook runs the commit hook if there is one
+func (c *command) fireHook(op string, keys ...[]byte) {
+	if c.hook == nil {
+		return
+	}
+	if c.cli.InTxn() {
+		for _, key := range keys {
+			c.pendingHooks = append(c.pendingHooks, hookEvent{op: op, key: key})
+		}
+		return
+	}
+	for _, key := range keys {
+		c.hook.fire(op, key)
+	}
+}
+
+// close waits for the background work to finish before exiting
+func (c *command) close() {
+	if c.cli != nil && c.cli.InTxn() {
+		c.cli.Rollback()
+		fmt.Fprintln(os.Stderr, "the active transaction is rolled back")
+	}
+	if c.hook != nil {
+		c.hook.wait()
+	}
+	if c.history != nil {
+		c.history.close()
+	}
+}
+
+func (c *command) delete(args []string) {
+	if c.deleteOpts.prefix != "" {
+		if len(args) > 0 {
+			c.printError(fmt.Errorf("delete takes keys or --prefix, not both"))
+			return
+		}
+		if c.inputOpts.hex || c.inputOpts.base64 {
+			c.printError(fmt.Errorf("--input-hex and --input-base64 decode the keys, the --prefix follows --key-encoding like truncate"))
+			return
+		}
+		prefix, err := decodeArg(c.deleteOpts.prefix, c.opts.KeyEncoding)
+		if err != nil {
+			c.printError(err)
+			return
+		}
+		c.deletePrefix("delete --prefix", prefix, c.stdinOpts.batch, c.deleteOpts.yes, false)
+		return
+	}
+	if len(args) == 0 {
+		c.printError(fmt.Errorf("key is required"))
+		return
+	}
+	if c.fromStdin(args) {
+		// the undo record would hold every batch
+		c.undo = nil
+		var total, deleted int64
+		n, err := c.readStdinKeys(func(keys [][]byte) error {
+			pairs, err := c.cli.BatchDelete(keys)
+			if err != nil {
+				return err
+			}
+			total += int64(len(keys))
+			deleted += int64(len(pairs))
+			c.fireHook("delete", keys...)
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "stopped at line %d\n", n)
+			c.printError(err)
+		}
+		fmt.Printf("Total deleted %d of %d keys\n", deleted, total)
+		return
+	}
+	keys, err := c.decodeArgs(args)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	// every key is reported, and like redis DEL the number of keys which
+	// actually existed. The existence is checked in the deleting transaction.
+	deleted, err := c.cli.BatchDelete(keys)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	// the deleted pairs follow the order of the keys, a repeated key is only
+	// deleted once
+	j := 0
+	for _, key := range keys {
+		if j < len(deleted) && bytes.Equal(deleted[j].Key, key) {
+			fmt.Printf("%s: deleted\n", tikvclient.DisplayKey(key))
+			j++
+			continue
+		}
+		fmt.Printf("%s: not found\n", tikvclient.DisplayKey(key))
+	}
+	fmt.Printf("(integer) %d\n", len(deleted))
+	c.undo = &undoRecord{op: "delete", pairs: deleted}
+	c.fireHook("delete", keys...)
+}
+
+// undoRecord is the before image of a mutation, a nil value means the key
+// did not exist
+type undoRecord struct {
+	op    string
+	pairs []kvPair
+}
+
+// beginTxn starts an explicit transaction in the shell
+func (c *command) beginTxn(args []string) {
+	if err := c.cli.Begin(); err != nil {
+		c.printError(err)
+	}
+}
+
+// commitTxn commits the explicit transaction and fires the commit hooks of its
+// writes
+func (c *command) commitTxn(args []string) {
+	// the undo record would revert a single write of the transaction
+	c.undo = nil
+	hooks := c.pendingHooks
+	c.pendingHooks = nil
+	if err := c.cli.Commit(); err != nil {
+		c.printError(err)
+		return
+	}
+	for _, h := range hooks {
+		c.fireHook(h.op, h.key)
+	}
+}
+
+// rollbackTxn discards the explicit transaction
+func (c *command) rollbackTxn(args []string) {
+	c.undo = nil
+	c.pendingHooks = nil
+	if err := c.cli.Rollback(); err != nil {
+		c.printError(err)
+	}
+}
+
+// undoLast reverts the last set or delete of the session in one transaction,
+// there is a single level of undo
+func (c *command) undoLast(args []string) {
+	if c.undo == nil || len(c.undo.pairs) == 0 {
+		c.printError(fmt.Errorf("nothing to undo"))
+		return
+	}
+	if err := c.cli.RestorePairs(c.undo.pairs); err != nil {
+		c.printError(err)
+		return
+	}
+	for _, p := range c.undo.pairs {
+		if p.Value == nil {
+			fmt.Printf("undo %s: deleted %q\n", c.undo.op, string(p.Key))
+			c.fireHook("delete", p.Key)
+			continue
+		}
+		fmt.Printf("undo %s: restored %q to %q\n", c.undo.op, string(p.Key), string(p.Value))
+		c.fireHook("set", p.Key)
+	}
+	c.undo = nil
+}
+
+func (c *command) scan(args []string) {
+	if c.scanOpts.start != "" || c.scanOpts.end != "" {
+		if c.scanOpts.reverse {
+			c.printError(fmt.Errorf("--start and --end can not be used with --reverse, use <begin> and --until"))
+			return
+		}
+		if c.scanOpts.until != "" {
+			c.printError(fmt.Errorf("--end and --until are mutually exclusive"))
+			return
+		}
+	}
+	if c.scanOpts.start != "" {
+		if len(args) > 0 {
+			c.printError(fmt.Errorf("--start and <begin> are mutually exclusive"))
+			return
+		}
+		args = []string{c.scanOpts.start}
+	}
+	var begin []byte
+	if len(args) == 0 {
+		if !c.scanOpts.reverse {
+			begin = []byte{0}
+		}
+	} else {
+		var err error
+		if begin, err = c.decodeKey(args[0]); err != nil {
+			c.printError(err)
+			return
+		}
+	}
+	c.scanOpts.untilKey = nil
+	if c.scanOpts.until != "" {
+		var err error
+		if c.scanOpts.untilKey, err = c.decodeKey(c.scanOpts.until); err != nil {
+			c.printError(err)
+			return
+		}
+	}
+	c.scanOpts.endKey = nil
+	if c.scanOpts.end != "" {
+		var err error
+		if c.scanOpts.endKey, err = c.decodeKey(c.scanOpts.end); err != nil {
+			c.printError(err)
+			return
+		}
+	}
+	if c.snapshotTS != 0 && c.scanOpts.delete {
+		c.printError(fmt.Errorf("--delete can not be used with --snapshot, a snapshot is read-only"))
+		return
+	}
+	if c.scanOpts.exec != "" && c.scanOpts.delete {
+		// the commands finish after the scan moved on, a key would be
+		// deleted even if its command failed
+		c.printError(fmt.Errorf("--exec can not be used with --delete"))
+		return
+	}
+	c.cli.SetSnapshot(c.snapshotTS)
+	defer c.cli.SetSnapshot(0)
+	if c.scanOpts.groupByValue && (c.scanOpts.withIndex || c.opts.tmpl != nil || c.opts.Output != "text" && c.opts.Output != "json") {
+		c.printError(fmt.Errorf("--group-by-value can only be used with --output text or json"))
+		return
+	}
+	if c.scanOpts.parallel > 1 {
+		start, err := c.scanAfter(begin)
+		if err != nil {
+			c.printError(err)
+			return
+		}
+		c.parallelScan(begin, start)
+		return
+	}
+	if c.scanOpts.reverse && c.scanOpts.delete {
+		c.printError(fmt.Errorf("--delete can not be used with --reverse"))
+		return
+	}
+	if c.scanOpts.dryRun && !c.scanOpts.delete {
+		c.printError(fmt.Errorf("--dry-run can only be used with --delete"))
+		return
+	}
+	// a dry run goes through the same checks and the same scan, it only does
+	// not delete the keys
+	deleting := c.scanOpts.delete && !c.scanOpts.dryRun
+	if deleting {
+		c.undo = nil
+	}
+
+	// start is where the iterator is positioned, inclusive for forward scans
+	// and exclusive for reverse ones
+	start := begin
+	if c.scanOpts.reverse && c.scanOpts.prefix {
+		start = prefixEnd(begin)
+	}
+	if c.scanOpts.cursor != "" {
+		key, err := decodeCursor(c.scanOpts.cursor, c.scanOpts.reverse)
+		if err != nil {
+			c.printError(err)
+			return
+		}
+		start = key
+		if !c.scanOpts.reverse {
+			start = kv.Key(key).Next()
+		}
+	}
+	start, err := c.scanAfter(start)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	// forward scans are bounded by the end key instead of stopping at the
+	// first key out of the prefix, a --cursor may start before the prefix
+	end := c.scanEnd(begin)
+	if !c.scanOpts.reverse && c.scanOpts.prefix && bytes.Compare(start, begin) < 0 {
+		start = begin
+	}
+	limit := c.scanOpts.limit
+	if c.scanOpts.pageSize > 0 {
+		limit = c.scanOpts.pageSize
+	}
+	scan := func(limit int64, deleteIf func(key []byte) bool, each func(key, val []byte) bool) (int64, error) {
+		if c.scanOpts.reverse {
+			return c.cli.ReverseScan(start, limit, each)
+		}
+		// the end bounds the iteration itself, so the key reaching it is
+		// neither emitted nor counted
+		return c.cli.ScanRange(start, end, limit, deleteIf, each)
+	}
+
+	// a scan without any bound deletes the whole keyspace, so it is confirmed
+	// even below the threshold
+	unbounded := !c.scanOpts.prefix && c.scanOpts.until == "" && c.scanOpts.end == "" && limit < 0
+	if deleting && !c.scanOpts.yes && (c.opts.ConfirmThreshold >= 0 || unbounded) {
+		threshold := c.opts.ConfirmThreshold
+		if unbounded {
+			threshold = 0
+		}
+		if !c.confirmAffected("scan and delete", threshold, func(max int64) (int64, error) {
+			n := limit
+			if n < 0 || n > max {
+				n = max
+			}
+			return scan(n, nil, func(key, val []byte) bool {
+				return c.scanMatch(begin, key)
+			})
+		}) {
+			return
+		}
+	}
+
+	if c.scanOpts.withIndex && c.opts.Output != "text" {
+		c.printError(fmt.Errorf("--with-index can only be used with --output text"))
+		return
+	}
+	filters, report, err := c.scanFilters()
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	w, sw, err := c.scanWriter()
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	var pipe *execPipe
+	if c.scanOpts.exec != "" {
+		if c.scanOpts.execConcurrency <= 0 {
+			c.printError(fmt.Errorf("--exec-concurrency should be greater than 0"))
+			return
+		}
+		pipe = newExecPipe(c.scanOpts.exec, c.scanOpts.execConcurrency, w.Write)
+	}
+	var last []byte    // the last key emitted
+	var visited []byte // the last key counted by the scan, filtered or not
+	// only the keys passing the filters are deleted, they are exactly the
+	// emitted ones
+	var deleteIf func(key []byte) bool
+	var emitted bool
+	var matched int64 // number of the keys emitted
+	if deleting {
+		deleteIf = func(key []byte) bool { return emitted }
+	}
+	count, err := scan(limit, deleteIf, func(key, val []byte) bool {
+		emitted = false
+		if !c.scanMatch(begin, key) {
+			return false
+		}
+		visited = append(visited[:0], key...)
+		if ok, err := applyFilters(filters, key, val); err != nil {
+			c.printError(err)
+			return false
+		} else if !ok {
+			return true
+		}
+		if pipe != nil {
+			if !pipe.submit(key, val) {
+				return false
+			}
+		} else if err := w.Write(key, val); err != nil {
+			c.printError(err)
+			return false
+		}
+		last = append(last[:0], key...)
+		emitted = true
+		matched++
+		return true
+	})
+	if pipe != nil {
+		if err := pipe.close(); err != nil {
+			c.printError(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		c.printError(err)
+	}
+	if err != nil {
+		c.printError(err)
+		// the filters may have skipped every scanned key
+		switch {
+		case matched > 0:
+			fmt.Fprintf(os.Stderr, "warning: scan is incomplete, the last emitted key is %s\n", tikvclient.DisplayKey(last))
+		case count > 0:
+			fmt.Fprintf(os.Stderr, "warning: scan is incomplete, no key was emitted, the last scanned key is %s\n", tikvclient.DisplayKey(visited))
+		default:
+			fmt.Fprintln(os.Stderr, "warning: scan is incomplete, no key was scanned")
+		}
+	}
+	if c.scanOpts.dryRun {
+		c.scanSummary("Would delete", matched)
+		if err == nil && matched == 0 && c.exitCode == 0 {
+			c.exitCode = tikvclient.ErrNotFound.ExitCode()
+		}
+	} else if !c.scanOpts.countOnly {
+		// the keys skipped by the filters are scanned but not counted, so
+		// the total is the number of the pairs emitted
+		c.scanSummary("Total scanned", matched)
+		if deleting && len(filters) > 0 {
+			c.scanSummary("Total deleted", matched)
+		}
+	}
+	c.printStats(sw)
+	// a full page may be followed by more keys
+	if err == nil && c.scanOpts.pageSize > 0 && count == c.scanOpts.pageSize {
+		c.scanSummary("Next cursor", encodeCursor(visited, c.scanOpts.reverse))
+	} else if err == nil && c.scanOpts.limit > 0 && count == c.scanOpts.limit {
+		// the key in the --key-encoding continues the scan with --after
+		c.scanSummary("Last key", encodeLine(visited, c.opts.KeyEncoding))
+	}
+	report()
+}
+
+// encodeCursor encodes the last key of a page into a cursor token, which
+// resumes a forward scan after the key or a reverse scan before it
+func encodeCursor(key []byte, reverse bool) string {
+	dir := byte('f')
+	if reverse {
+		dir = 'r'
+	}
+	return base64.RawURLEncoding.EncodeToString(append([]byte{dir}, key...))
+}
+
+// decodeCursor decodes a cursor token into the last key of the previous page
+func decodeCursor(cursor string, reverse bool) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(data) == 0 || (data[0] != 'f' && data[0] != 'r') {
+		return nil, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	if (data[0] == 'r') != reverse {
+		return nil, fmt.Errorf("the cursor was not created by a scan in the same direction")
+	}
+	return data[1:], nil
+}
+
+// newOutputWriter creates the writer selected by the options
+func (c *command) newOutputWriter(w io.Writer) outputWriter {
+	ow := newOutputWriter(w, c.opts)
+	if mw, ok := ow.(*metaWriter); ok {
+		mw.commitTS = c.cli.CommitTS
+	}
+	return ow
+}
+
+// scanSummary prints a summary line of scan, it goes to stderr so stdout
+// holds nothing but the results, like a valid JSON or CSV stream
+func (c *command) scanSummary(a ...interface{}) {
+	fmt.Fprintln(os.Stderr, a...)
+}
+
+// scanWriter creates the writer of the scan results selected by the scan
+// options, the returned statsWriter is nil unless --stats-footer
+func (c *command) scanWriter() (outputWriter, *statsWriter, error) {
+	if c.scanOpts.countOnly && (c.scanOpts.sum || c.scanOpts.count || c.scanOpts.keysOnly || c.scanOpts.valuesOnly ||
+		c.scanOpts.showSize || c.scanOpts.groupByValue || c.scanOpts.withIndex || c.scanOpts.keysOut != "" || c.scanOpts.valuesOut != "") {
+		return nil, nil, fmt.Errorf("--count-only prints nothing but the number, it can not be combined with other output flags")
+	}
+	if c.scanOpts.showSize && (c.scanOpts.keysOnly || c.scanOpts.valuesOnly || c.scanOpts.sum || c.scanOpts.count) {
+		return nil, nil, fmt.Errorf("--show-size can not be used with --keys-only, --values-only, --sum or --count")
+	}
+	if c.scanOpts.topBySize > 0 && c.scanOpts.delete {
+		return nil, nil, fmt.Errorf("--top-by-size can not be used with --delete")
+	}
+	var w outputWriter
+	header := c.scanOpts.header // left over unless a csv or tsv writer takes it
+	switch {
+	case c.scanOpts.keysOut != "" || c.scanOpts.valuesOut != "":
+		if c.scanOpts.groupByValue {
+			return nil, nil, fmt.Errorf("--keys-out and --values-out can not be used with --group-by-value")
+		}
+		fw, err := c.splitFileWriter()
+		if err != nil {
+			return nil, nil, err
+		}
+		w = fw
+	case c.scanOpts.groupByValue:
+		if c.scanOpts.keysOnly {
+			return nil, nil, fmt.Errorf("--keys-only can not be used with --group-by-value")
+		}
+		if c.scanOpts.valuesOnly || c.scanOpts.sum || c.scanOpts.count {
+			return nil, nil, fmt.Errorf("--values-only, --sum and --count can not be used with --group-by-value")
+		}
+		w = newGroupWriter(os.Stdout, c.opts, c.scanOpts.groupLimit)
+	case (c.scanOpts.keysOnly || c.scanOpts.valuesOnly) && (c.scanOpts.sum || c.scanOpts.count):
+		return nil, nil, fmt.Errorf("--sum and --count can not be used with --keys-only or --values-only")
+	case c.scanOpts.countOnly:
+		w = &aggregateWriter{w: os.Stdout, bare: true}
+	case c.scanOpts.sum || c.scanOpts.count:
+		if c.scanOpts.sum && c.scanOpts.count {
+			return nil, nil, fmt.Errorf("--sum and --count are mutually exclusive")
+		}
+		if c.scanOpts.withIndex {
+			return nil, nil, fmt.Errorf("--with-index can not be used with --sum or --count")
+		}
+		w = &aggregateWriter{w: os.Stdout, sum: c.scanOpts.sum, json: c.opts.Output == "json"}
+	case c.scanOpts.keysOnly && c.scanOpts.valuesOnly:
+		return nil, nil, fmt.Errorf("--keys-only and --values-only are mutually exclusive")
+	case c.scanOpts.keysOnly || c.scanOpts.valuesOnly:
+		kw, err := newKeyWriter(os.Stdout, c.opts, c.scanOpts.valuesOnly)
+		if err != nil {
+			return nil, nil, err
+		}
+		w = kw
+		if c.scanOpts.withIndex {
+			w = &indexWriter{outputWriter: w, w: os.Stdout}
+		}
+	case c.scanOpts.showSize:
+		sw, err := newSizeWriter(os.Stdout, c.opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		w = sw
+		if c.scanOpts.withIndex {
+			w = &indexWriter{outputWriter: w, w: os.Stdout}
+		}
+	default:
+		w = c.newOutputWriter(os.Stdout)
+		switch ow := w.(type) {
+		case *csvWriter:
+			ow.header = c.scanOpts.header
+			header = false
+		case *tsvWriter:
+			ow.header = c.scanOpts.header
+			header = false
+		}
+		var out io.Writer = os.Stdout
+		if cw, ok := w.(*colorWriter); ok && c.scanOpts.align {
+			out = cw.alignColumns()
+		}
+		if c.scanOpts.withIndex {
+			w = &indexWriter{outputWriter: w, w: out}
+		}
+	}
+	if c.scanOpts.topBySize < 0 {
+		return nil, nil, fmt.Errorf("--top-by-size can not be negative")
+	} else if c.scanOpts.topBySize > 0 {
+		if c.scanOpts.groupByValue {
+			return nil, nil, fmt.Errorf("--top-by-size can not be used with --group-by-value")
+		}
+		w = &topSizeWriter{outputWriter: w, n: c.scanOpts.topBySize}
+	}
+	if header {
+		return nil, nil, fmt.Errorf("--header can only be used with --output csv or tsv printing the pairs")
+	}
+	sw := c.statsWriter(w)
+	if sw != nil {
+		w = sw
+	}
+	return w, sw, nil
+}
+
+// splitFileWriter creates the files of --keys-out and --values-out
+func (c *command) splitFileWriter() (*splitFileWriter, error) {
+	fw := &splitFileWriter{}
+	for _, out := range []struct {
+		path, encoding string
+		file           **lineFile
+	}{
+		{c.scanOpts.keysOut, c.scanOpts.keysOutEncoding, &fw.keys},
+		{c.scanOpts.valuesOut, c.scanOpts.valuesOutEncoding, &fw.values},
+	} {
+		if out.path == "" {
+			continue
+		}
+		switch out.encoding {
+		case "escape", "hex", "base64":
+		default:
+			fw.Flush()
+			return nil, fmt.Errorf("unknown encoding %q of %s, should be escape, hex or base64", out.encoding, out.path)
+		}
+		lf, err := createLineFile(out.path, out.encoding)
+		if err != nil {
+			fw.Flush()
+			return nil, err
+		}
+		*out.file = lf
+	}
+	return fw, nil
+}
+
+// statsWriter wraps the writer to collect the stats of --stats-footer, it
+// returns nil if they are not requested
+func (c *command) statsWriter(w outputWriter) *statsWriter {
+	if !c.scanOpts.statsFooter {
+		return nil
+	}
+	return &statsWriter{outputWriter: w, begin: time.Now()}
+}
+
+// printStats prints the footer of --stats-footer
+func (c *command) printStats(sw *statsWriter) {
+	if sw == nil {
+		return
+	}
+	for _, line := range sw.stats().footer(c.opts.Output == "json") {
+		c.scanSummary(line)
+	}
+}
+
+// scanFilters returns the filters enabled by the scan options and a function
+// reporting their statistics after the scan
+func (c *command) scanFilters() ([]scanFilter, func(), error) {
+	var filters []scanFilter
+	var reports []func()
+	// the sizes and the substrings are checked first since they are the cheapest
+	if c.scanOpts.minValueSize > 0 {
+		filters = append(filters, minSizeFilter(c.scanOpts.minValueSize))
+	}
+	if c.scanOpts.keyContains != "" {
+		filters = append(filters, containsFilter([]byte(c.scanOpts.keyContains), false, c.scanOpts.ignoreCase))
+	}
+	if c.scanOpts.valueContains != "" {
+		filters = append(filters, containsFilter([]byte(c.scanOpts.valueContains), true, c.scanOpts.ignoreCase))
+	}
+	if c.scanOpts.match != "" {
+		re, err := regexp.Compile(c.scanOpts.match)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --match: %v", err)
+		}
+		filters = append(filters, matchFilter(re))
+	}
+	if c.scanOpts.where != "" {
+		e, err := compileWhere(c.scanOpts.where)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, e.filter)
+	}
+	if c.scanOpts.keyFilterFile != "" {
+		keys, err := loadKeySet(c.scanOpts.keyFilterFile, c.opts.KeyEncoding)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, keys.filter)
+	}
+	if c.scanOpts.sampleRate != 1 {
+		s, err := newSampler(c.scanOpts.sampleRate, c.scanOpts.seed)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, s.filter)
+	}
+	// dedup goes last to count only the pairs passing the other filters
+	if c.scanOpts.dedupValues {
+		d := newDedup(false, c.scanOpts.dedupLimit)
+		filters = append(filters, d.filter)
+		reports = append(reports, func() { c.scanSummary("Distinct values", int64(d.distinct())) })
+	}
+	if c.scanOpts.dedupKeys {
+		d := newDedup(true, c.scanOpts.dedupLimit)
+		filters = append(filters, d.filter)
+		reports = append(reports, func() { c.scanSummary("Distinct keys", int64(d.distinct())) })
+	}
+	return filters, func() {
+		for _, report := range reports {
+			report()
+		}
+	}, nil
+}
+
+// scanMatch checks the key against the lower bounds of reverse scans, they
+// stop at the first key not matched. Forward scans are bounded by scanEnd.
+func (c *command) scanMatch(begin, key []byte) bool {
+	if !c.scanOpts.reverse {
+		return true
+	}
+	if c.scanOpts.prefix && !bytes.HasPrefix(key, begin) {
+		return false
+	}
+	if c.scanOpts.until != "" && bytes.Compare(key, c.scanOpts.untilKey) < 0 {
+		return false
+	}
+	return true
+}
+
+// scanEnd returns the exclusive end of a forward scan from --prefix, --until
+// and --end, the closest one wins and nil means there is no bound
+func (c *command) scanEnd(begin []byte) []byte {
+	var end []byte
+	if c.scanOpts.prefix {
+		end = prefixEnd(begin)
+	}
+	if c.scanOpts.until != "" {
+		// until is inclusive
+		until := kv.Key(c.scanOpts.untilKey).Next()
+		if end == nil || bytes.Compare(until, end) < 0 {
+			end = until
+		}
+	}
+	if c.scanOpts.end != "" && (end == nil || bytes.Compare(c.scanOpts.endKey, end) < 0) {
+		end = c.scanOpts.endKey
+	}
+	return end
+}
+
+// prefixEnd returns the smallest key greater than all the keys with the
+// prefix, nil if there is none like for an empty prefix
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append([]byte{}, prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// scanAfter moves the start of the scan past the --after key, the start
+// closer to the end of the scan wins if both restrict it
+func (c *command) scanAfter(start []byte) ([]byte, error) {
+	if c.scanOpts.after == "" {
+		return start, nil
+	}
+	if c.scanOpts.cursor != "" {
+		return nil, fmt.Errorf("--after and --cursor are mutually exclusive")
+	}
+	after, err := decodeArg(c.scanOpts.after, c.opts.KeyEncoding)
+	if err != nil {
+		return nil, err
+	}
+	if c.scanOpts.reverse {
+		// the start of reverse scans is already exclusive, an empty one is the end of the keyspace
+		if len(start) == 0 || bytes.Compare(after, start) < 0 {
+			return after, nil
+		}
+		return start, nil
+	}
+	// the successor of the key is the smallest key greater than it
+	if next := kv.Key(after).Next(); bytes.Compare(next, start) > 0 {
+		return next, nil
+	}
+	return start, nil
+}
+
+// parallelScan scans the range concurrently with a consistent snapshot,
+// begin is the prefix for --prefix and start is where the range begins
+func (c *command) parallelScan(begin, start []byte) {
+	if c.scanOpts.limit >= 0 || c.scanOpts.delete {
+		c.printError(fmt.Errorf("--limit and --delete can not be used with --parallel"))
+		return
+	}
+	if c.scanOpts.orderBuffer <= 0 {
+		c.printError(fmt.Errorf("--order-buffer should be greater than 0"))
+		return
+	}
+	// the sub-ranges are bounded by the end key instead of filtering
+	end := c.scanEnd(begin)
+
+	filters, report, err := c.scanFilters()
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	w, sw, err := c.scanWriter()
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	e := newOrderedEmitter(w, c.scanOpts.parallel, c.scanOpts.ordered, c.scanOpts.orderBuffer)
+	var ferr error
+	var mu sync.Mutex
+	var matched int64 // number of the pairs emitted
+	_, err = c.cli.ParallelScan(start, end, c.scanOpts.parallel, func(part int, key, val []byte) bool {
+		if ok, err := applyFilters(filters, key, val); err != nil {
+			mu.Lock()
+			ferr = err
+			mu.Unlock()
+			return false
+		} else if !ok {
+			return true
+		}
+		if !e.emit(part, key, val) {
+			return false
+		}
+		atomic.AddInt64(&matched, 1)
+		return true
+	}, e.finish)
+	if ferr != nil {
+		c.printError(ferr)
+	}
+	if err := e.error(); err != nil {
+		c.printError(err)
+	}
+	if err := w.Flush(); err != nil {
+		c.printError(err)
+	}
+	if err != nil {
+		c.printError(err)
+	}
+	if !c.scanOpts.countOnly {
+		c.scanSummary("Total scanned", matched)
+	}
+	c.printStats(sw)
+	report()
+}
+
+// scanOptions are the flags of scan, get --prefix runs a scan with them
+type scanOptions struct {
+	limit  int64  // number of results
+	prefix bool   // prefix match
+	until  string // end key
+	delete bool   // delete all scanned keys
+	dryRun bool   // scan the keys --delete would delete without deleting them
+	yes    bool   // delete without confirmation
+
+	untilKey []byte // until decoded with the --key-encoding
+
+	start  string // first key, the same as <begin>
+	end    string // end key, exclusive unlike until
+	endKey []byte // end decoded with the --key-encoding
+
+	parallel    int  // number of concurrently scanned sub-ranges
+	ordered     bool // keep the key order when scanning in parallel
+	orderBuffer int  // max number of pairs buffered for reordering
+
+	dedupValues bool // emit only the first key of every distinct value
+	dedupKeys   bool // collapse duplicated keys
+	dedupLimit  int  // max number of distinct values or keys remembered
+
+	withIndex bool // number the results from 1
+	keysOnly  bool // print only the keys
+
+	valuesOnly bool // print only the values
+	sum        bool // print the sum of the integer values instead of the pairs
+	count      bool // print the number of the pairs instead of the pairs
+
+	countOnly bool // print only the bare number of the pairs, and no summary
+
+	header bool // print the column names before the csv or tsv records
+
+	reverse  bool   // scan in descending order
+	pageSize int64  // number of keys of a page
+	cursor   string // resume after the page which returned this cursor
+	after    string // exclusive start key
+
+	exec            string // pipe every value through this shell command
+	execConcurrency int    // max number of commands running at the same time
+
+	keyFilterFile string // emit only the keys listed in this file
+
+	keyContains   string // emit only the keys containing this substring
+	valueContains string // emit only the values containing this substring
+	ignoreCase    bool   // match the substrings case insensitively
+	match         string // emit only the keys matching this regexp
+	where         string // emit only the pairs matching this expression
+
+	sampleRate float64 // probability of emitting a pair
+	seed       int64   // seed of the sampling, 0 for a random one
+
+	statsFooter bool // print the value size percentiles after the scan
+
+	showSize     bool // print the sizes of the key and the value of every pair
+	minValueSize int  // emit only the pairs with values of at least this many bytes
+	topBySize    int  // print only this many pairs with the largest values
+
+	keysOut           string // write the keys to this file instead of stdout
+	valuesOut         string // write the values to this file instead of stdout
+	keysOutEncoding   string
+	valuesOutEncoding string
+
+	groupByValue bool // print the keys grouped by value
+	groupLimit   int  // max number of keys buffered by groupByValue
+
+	align bool // align the colorized values of a terminal
+}
+
+// defaultScanOptions returns the defaults of the scan flags
+func defaultScanOptions() scanOptions {
+	return scanOptions{
+		limit:             -1,
+		parallel:          1,
+		orderBuffer:       10000,
+		dedupLimit:        1000000,
+		execConcurrency:   4,
+		sampleRate:        1,
+		keysOutEncoding:   "escape",
+		valuesOutEncoding: "escape",
+		groupLimit:        100000,
+	}
+}
+
+func (c *command) scanFlags(fs *pflag.FlagSet, untilShorthand string) {
+	d := defaultScanOptions()
+	fs.Int64VarP(&c.scanOpts.limit, "limit", "n", d.limit, "number of values to be scanned")
+	fs.BoolVarP(&c.scanOpts.prefix, "prefix", "p", false, "match with prefix")
+	fs.StringVarP(&c.scanOpts.until, "until", untilShorthand, "", "scan until match this key")
+	fs.StringVar(&c.scanOpts.start, "start", "", "first key of the range, inclusive, the same as <begin>")
+	fs.StringVar(&c.scanOpts.end, "end", "", "end key of the range, exclusive unlike --until, the scan stops at it")
+	fs.BoolVarP(&c.scanOpts.delete, "delete", "d", false, "delete scanned keys")
+	fs.BoolVarP(&c.scanOpts.yes, "yes", "y", false, "do not ask for confirmation before --delete")
+	fs.BoolVar(&c.scanOpts.yes, "force", false, "alias of --yes")
+	fs.BoolVar(&c.scanOpts.dryRun, "dry-run", false, "with --delete, print the keys which would be deleted and delete nothing")
+	fs.IntVar(&c.scanOpts.parallel, "parallel", d.parallel, "number of sub-ranges scanned concurrently from one snapshot, results are interleaved unless --ordered")
+	fs.BoolVar(&c.scanOpts.ordered, "ordered", false, "reorder the results of --parallel into key order")
+	fs.IntVar(&c.scanOpts.orderBuffer, "order-buffer", d.orderBuffer, "max number of pairs buffered by --ordered, the scan fails if it is exceeded")
+	fs.BoolVar(&c.scanOpts.dedupValues, "dedup-values", false, "print only the first key of every distinct value")
+	fs.BoolVar(&c.scanOpts.dedupKeys, "dedup-keys", false, "collapse duplicated keys")
+	fs.BoolVarP(&c.scanOpts.reverse, "reverse", "r", false, "scan in descending order from <begin> (exclusive) or the end of the keyspace")
+	fs.Int64Var(&c.scanOpts.pageSize, "page-size", 0, "scan a page of this many keys and print the cursor of the next page")
+	fs.StringVar(&c.scanOpts.cursor, "cursor", "", "resume the scan from the cursor printed by the previous page")
+	fs.StringVar(&c.scanOpts.after, "after", "", "start strictly after this key in the --key-encoding, exclusive unlike <begin>")
+	fs.StringVar(&c.scanOpts.exec, "exec", "", "pipe every value into this shell command and print its stdout as the value, e.g. 'gunzip'")
+	fs.IntVar(&c.scanOpts.execConcurrency, "exec-concurrency", d.execConcurrency, "max number of --exec commands running at the same time")
+	fs.StringVar(&c.scanOpts.keyFilterFile, "key-filter-file", "", "emit only the keys listed in this file, one per line in the --key-encoding")
+	fs.StringVar(&c.scanOpts.keyContains, "key-contains", "", "emit only the keys containing this literal substring")
+	fs.StringVar(&c.scanOpts.valueContains, "value-contains", "", "emit only the keys whose value contains this literal substring")
+	fs.StringVar(&c.scanOpts.match, "match", "", "emit only the keys matching this Go regexp, matched against the raw key bytes as a string")
+	fs.StringVar(&c.scanOpts.where, "where", "", `emit only the pairs matching the expression, e.g. 'valueLen > 1024 && hasPrefix(key, "log:")'`)
+	fs.Float64Var(&c.scanOpts.sampleRate, "sample-rate", d.sampleRate, "emit every pair with this probability, e.g. 0.01, the whole range is still scanned")
+	fs.Int64Var(&c.scanOpts.seed, "seed", 0, "seed of --sample-rate for a reproducible sample, 0 for a random one")
+	fs.BoolVar(&c.scanOpts.ignoreCase, "ignore-case", false, "match --key-contains and --value-contains case insensitively")
+	fs.StringVar(&c.scanOpts.keysOut, "keys-out", "", "write the keys to this file, one per line, instead of stdout")
+	fs.StringVar(&c.scanOpts.valuesOut, "values-out", "", "write the values to this file, one per line matching --keys-out, instead of stdout")
+	fs.StringVar(&c.scanOpts.keysOutEncoding, "keys-out-encoding", d.keysOutEncoding, "encoding of the lines of --keys-out: escape, hex or base64")
+	fs.StringVar(&c.scanOpts.valuesOutEncoding, "values-out-encoding", d.valuesOutEncoding, "encoding of the lines of --values-out: escape, hex or base64")
+	fs.BoolVar(&c.scanOpts.showSize, "show-size", false, "print the sizes of the key and the value after every pair")
+	fs.IntVar(&c.scanOpts.minValueSize, "min-value-size", 0, "emit only the pairs whose value has at least this many bytes")
+	fs.IntVar(&c.scanOpts.topBySize, "top-by-size", 0, "print only the N pairs with the largest values, largest first, after the scan")
+	fs.BoolVar(&c.scanOpts.statsFooter, "stats-footer", false, "print the total bytes, the value size percentiles and the elapsed time after the scan")
+	fs.BoolVar(&c.scanOpts.groupByValue, "group-by-value", false, "buffer the scanned range and print the keys grouped by value")
+	fs.IntVar(&c.scanOpts.groupLimit, "group-limit", d.groupLimit, "max number of keys buffered by --group-by-value, the scan fails if it is exceeded")
+	fs.BoolVarP(&c.scanOpts.keysOnly, "keys-only", "k", false, "print only the keys, one per line")
+	fs.BoolVar(&c.scanOpts.valuesOnly, "values-only", false, "print only the values, one per line")
+	fs.BoolVar(&c.scanOpts.sum, "sum", false, "print the sum of the values as decimal integers instead of the pairs, a non-integer value fails the scan")
+	fs.BoolVar(&c.scanOpts.count, "count", false, "print the number of the pairs passing the filters instead of the pairs")
+	fs.BoolVar(&c.scanOpts.countOnly, "count-only", false, "print only the bare number of the pairs passing the filters, without any summary")
+	fs.BoolVar(&c.scanOpts.header, "header", false, "print a header row of the column names with --output csv or tsv")
+	fs.BoolVarP(&c.scanOpts.withIndex, "with-index", "N", false, "prefix every result with its 1-based index")
+	fs.IntVar(&c.scanOpts.dedupLimit, "dedup-limit", d.dedupLimit, "max number of distinct values or keys remembered by --dedup-*, the scan fails if it is exceeded")
+	fs.BoolVar(&c.scanOpts.align, "align", false, "align the values in a column when the output is colorized on a terminal")
+	c.snapshotFlags(fs)
+}
+
+// rename moves a key, or all the keys under a prefix, to a new name
+func (c *command) rename(args []string) {
+	c.undo = nil
+	if len(args) != 2 {
+		c.printError(fmt.Errorf("source and target are required"))
+		return
+	}
+	names, err := c.decodeArgs(args)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	if !c.renameOpts.prefix {
+		if err := c.cli.Rename(names[0], names[1], c.renameOpts.overwrite); err != nil {
+			c.printError(err)
+		}
+		return
+	}
+	count, err := c.cli.RenamePrefix(names[0], names[1], c.renameOpts.overwrite)
+	if err != nil {
+		c.printError(err)
+		if tikvclient.IsCollision(err) {
+			fmt.Println("nothing was renamed, use --overwrite to replace the existing keys")
+		}
+		return
+	}
+	fmt.Println("Total renamed", count)
+}
+
+func (c *command) renameFlags(fs *pflag.FlagSet) {
+	c.inputFlags(fs)
+	fs.BoolVarP(&c.renameOpts.prefix, "prefix", "p", false, "rename all the keys under the source prefix to the target prefix")
+	fs.BoolVar(&c.renameOpts.overwrite, "overwrite", false, "replace the target keys which already exist")
+	fs.BoolVar(&c.renameOpts.overwrite, "force", false, "alias of --overwrite")
+}
+
+// copyValue duplicates the value of a key under a new name
+func (c *command) copyValue(args []string) {
+	c.undo = nil
+	if len(args) != 2 {
+		c.printError(fmt.Errorf("source and target are required"))
+		return
+	}
+	names, err := c.decodeArgs(args)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	if err := c.cli.Copy(names[0], names[1], c.copyOpts.overwrite); err != nil {
+		c.printError(err)
+		if tikvclient.IsCollision(err) {
+			fmt.Println("nothing was copied, use --overwrite to replace the existing key")
+		}
+	}
+}
+
+func (c *command) copyFlags(fs *pflag.FlagSet) {
+	c.inputFlags(fs)
+	fs.BoolVar(&c.copyOpts.overwrite, "overwrite", false, "replace the target key if it already exists")
+	fs.BoolVar(&c.copyOpts.overwrite, "force", false, "alias of --overwrite")
+}
+
+// doctor diagnoses the connection to the cluster
+func (c *command) doctor(args []string) {
+	printDiagnoses(tikvclient.Diagnose(c.opts.Url, c.keyspace()), c.opts)
+}
+
+// selectDB switches to the logical database given by args[0]
+func (c *command) selectDB(args []string) {
+	// the keys of the undo record belong to the previous db
+	c.undo = nil
+	if len(args) != 1 {
+		c.printError(fmt.Errorf("db number is required"))
+		return
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		c.printError(fmt.Errorf("invalid db number %s", args[0]))
+		return
+	}
+	c.opts.DB = n
+	c.cli.SetKeyspace(c.keyspace())
 }
 
-type command struct {
-	cli *TikvClient
+// truncateProgressInterval is how often truncate reports its progress
+const truncateProgressInterval = time.Second
 
-	scanOpts struct {
-		limit  int64  // number of results
-		prefix bool   // prefix match
-		until  string // end key
-		delete bool   // delete all scanned keys
+// truncate deletes all the keys with the --prefix in batched transactions,
+// it never asks but requires --yes, and --i-know-what-im-doing as well if
+// the prefix is empty which deletes the whole keyspace
+func (c *command) truncate(args []string) {
+	c.undo = nil
+	if len(args) > 0 {
+		c.printError(fmt.Errorf("truncate takes no argument, the keys are given by --prefix"))
+		return
 	}
+	prefix, err := decodeArg(c.truncateOpts.prefix, c.opts.KeyEncoding)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	c.deletePrefix("truncate", prefix, c.truncateOpts.batch, c.truncateOpts.yes, c.truncateOpts.wholeKeyspace)
 }
 
-func (c *command) get(args []string) {
-	if len(args) == 0 {
-		fmt.Println("key is required")
+// deletePrefix deletes the keys with the prefix in transactions of batch keys
+// for truncate and delete --prefix. It never asks but requires yes, and
+// wholeKeyspace as well if the prefix is empty.
+func (c *command) deletePrefix(cmd string, prefix []byte, batch int, yes, wholeKeyspace bool) {
+	c.undo = nil
+	if batch <= 0 {
+		c.printError(fmt.Errorf("batch should be greater than 0"))
+		return
 	}
-	for i := range args {
-		key := args[i]
-		fmt.Printf("%q\n", string(hexEscape(key)))
-		val, err := c.cli.Get([]byte(hexEscape(key)))
-		if err != nil {
-			fmt.Println(err)
+	if !yes {
+		c.printError(fmt.Errorf("%s deletes every key with the prefix, pass --yes to confirm", cmd))
+		return
+	}
+	if len(prefix) == 0 && !wholeKeyspace {
+		c.printError(fmt.Errorf("an empty prefix deletes the whole keyspace, pass --i-know-what-im-doing as well to confirm"))
+		return
+	}
+	// --yes is required, so only a threshold asks
+	if c.opts.ConfirmThreshold >= 0 && !c.confirmAffected("delete all the keys with the prefix "+tikvclient.DisplayKey(prefix), c.opts.ConfirmThreshold, func(max int64) (int64, error) {
+		return c.cli.ScanRange(prefix, prefixEnd(prefix), max, nil, func(key, val []byte) bool { return true })
+	}) {
+		return
+	}
+	last := time.Now()
+	count, err := c.cli.DeletePrefix(prefix, batch, func(deleted int64) {
+		if time.Since(last) >= truncateProgressInterval {
+			last = time.Now()
+			fmt.Fprintf(os.Stderr, "deleted %d keys so far\n", deleted)
+		}
+	})
+	if err != nil {
+		c.printError(err)
+	}
+	fmt.Println("Total deleted", count)
+}
+
+// flushdb deletes all the keys of the current logical database
+func (c *command) flushdb(args []string) {
+	c.undo = nil
+	if c.opts.DB < 0 {
+		c.printError(fmt.Errorf("no db is selected, use --db or select first"))
+		return
+	}
+	if c.flushOpts.batch <= 0 {
+		c.printError(fmt.Errorf("batch should be greater than 0"))
+		return
+	}
+	if !c.flushOpts.yes {
+		what := fmt.Sprintf("delete all the keys of db %d", c.opts.DB)
+		if len(c.opts.namespace) > 0 {
+			what += fmt.Sprintf(" in the namespace %q", c.opts.namespace)
+		}
+		if c.opts.ConfirmThreshold < 0 {
+			if !confirm(what + "?") {
+				return
+			}
+		} else if !c.confirmAffected(what, c.opts.ConfirmThreshold, func(max int64) (int64, error) {
+			return c.cli.Scan(nil, max, nil, func(key, val []byte) bool { return true })
+		}) {
 			return
 		}
-		fmt.Printf("%q\n", string(val))
 	}
+	count, err := c.cli.DeletePrefix(nil, c.flushOpts.batch, nil)
+	if err != nil {
+		c.printError(err)
+	}
+	fmt.Println("Total deleted", count)
 }
-func (c *command) set(args []string) {
-	if len(args) != 2 {
+
+// exists checks which candidate keys exist with a single scan of the prefix
+// instead of a get per key, the candidates come from the arguments and
+// the --against-file
+func (c *command) exists(args []string) {
+	if c.existsOpts.prefix == "" && c.existsOpts.againstFile == "" {
+		c.existsKeys(args)
+		return
+	}
+	candidates := make(keySet)
+	if c.existsOpts.againstFile != "" {
+		var err error
+		if candidates, err = loadKeySet(c.existsOpts.againstFile, c.opts.KeyEncoding); err != nil {
+			c.printError(err)
+			return
+		}
+	}
+	keys, err := c.decodeArgs(args)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	for _, key := range keys {
+		candidates[string(key)] = struct{}{}
+	}
+	prefix, err := decodeArg(c.existsOpts.prefix, c.opts.KeyEncoding)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	// the keys outside the prefix can not be found by the scan
+	var sorted []string
+	var outside int
+	for key := range candidates {
+		if !strings.HasPrefix(key, string(prefix)) {
+			outside++
+			continue
+		}
+		sorted = append(sorted, key)
+	}
+	if len(sorted) == 0 {
+		c.printError(fmt.Errorf("no candidate key under the prefix, use --against-file or pass the keys"))
 		return
 	}
-	key, val := args[0], args[1]
-	err := c.cli.Set([]byte(hexEscape(key)), []byte(hexEscape(val)))
+	sort.Strings(sorted)
+
+	present := make(map[string]bool, len(sorted))
+	last := []byte(sorted[len(sorted)-1])
+	_, err = c.cli.Scan([]byte(sorted[0]), -1, nil, func(key, val []byte) bool {
+		if bytes.Compare(key, last) > 0 {
+			return false
+		}
+		if _, ok := candidates[string(key)]; ok {
+			present[string(key)] = true
+		}
+		return len(present) < len(sorted)
+	})
 	if err != nil {
-		fmt.Println(err)
+		c.printError(err)
 		return
 	}
+	if c.existsOpts.list {
+		for _, key := range sorted {
+			mark := "-"
+			if present[key] {
+				mark = "+"
+			}
+			fmt.Println(mark, encodeLine([]byte(key), c.opts.KeyEncoding))
+		}
+	}
+	fmt.Println("Present", len(present))
+	fmt.Println("Absent", len(sorted)-len(present))
+	if outside > 0 {
+		fmt.Println("Outside the prefix", outside)
+	}
 }
 
-func (c *command) delete(args []string) {
+// existsKeys looks the keys up one by one and prints true or false for each,
+// the exit status is the one of not found errors if any key is absent
+func (c *command) existsKeys(args []string) {
 	if len(args) == 0 {
-		fmt.Println("key is required")
+		c.printError(fmt.Errorf("key is required"))
+		return
+	}
+	keys, err := c.decodeArgs(args)
+	if err != nil {
+		c.printError(err)
+		return
 	}
-	for i := range args {
-		key := args[i]
-		if err := c.cli.Delete([]byte(hexEscape(key))); err != nil {
-			fmt.Println(err)
+	for _, key := range keys {
+		ok, err := c.cli.Exists(key)
+		if err != nil {
+			c.printError(err)
 			return
 		}
+		fmt.Println(ok)
+		if !ok && c.exitCode == 0 {
+			c.exitCode = tikvclient.ErrNotFound.ExitCode()
+		}
 	}
 }
 
-func (c *command) scan(args []string) {
-	var begin []byte
-	if len(args) == 0 {
-		begin = []byte{0}
-	} else {
-		begin = []byte(args[0])
+func (c *command) existsFlags(fs *pflag.FlagSet) {
+	c.inputFlags(fs)
+	fs.StringVar(&c.existsOpts.prefix, "prefix", "", "the prefix of the candidate keys, it is scanned once")
+	fs.StringVar(&c.existsOpts.againstFile, "against-file", "", "file of the candidate keys, one per line in the --key-encoding")
+	fs.BoolVar(&c.existsOpts.list, "list", false, "list the candidate keys in key order, prefixed with + if present and - if absent")
+}
+
+// count prints the number of keys in the range as a plain integer
+func (c *command) count(args []string) {
+	if len(args) > 1 {
+		c.printError(fmt.Errorf("at most one begin key is allowed"))
+		return
+	}
+	begin, end, err := c.decodeRange(args, c.countOpts.prefix, c.countOpts.until)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	n, err := c.cli.Count(begin, end, c.countOpts.limit)
+	if err != nil {
+		c.printError(err)
+		return
 	}
+	fmt.Println(n)
+}
 
-	count, err := c.cli.Scan(begin, c.scanOpts.limit, c.scanOpts.delete, func(key, val []byte) bool {
-		// match begin as prefix
-		if c.scanOpts.prefix {
-			if !bytes.HasPrefix(key, begin) {
-				return false
-			}
+// decodeRange decodes the optional begin argument and the inclusive until
+// key, and returns the exclusive end of the range of the keys with the prefix
+// begin if prefix is set, a nil end means there is no bound
+func (c *command) decodeRange(args []string, prefix bool, until string) (begin, end []byte, err error) {
+	if len(args) == 1 {
+		if begin, err = c.decodeKey(args[0]); err != nil {
+			return nil, nil, err
 		}
-		// scan until certain key
-		if c.scanOpts.until != "" {
-			if bytes.Compare(key, []byte(c.scanOpts.until)) > 0 {
-				return false
+	}
+	if prefix {
+		end = prefixEnd(begin)
+	}
+	if until != "" {
+		key, err := c.decodeKey(until)
+		if err != nil {
+			return nil, nil, err
+		}
+		next := kv.Key(key).Next()
+		if len(end) == 0 || bytes.Compare(next, end) < 0 {
+			end = next
+		}
+	}
+	return begin, end, nil
+}
+
+func (c *command) countFlags(fs *pflag.FlagSet) {
+	fs.Int64VarP(&c.countOpts.limit, "limit", "n", -1, "stop counting at this many keys")
+	fs.BoolVarP(&c.countOpts.prefix, "prefix", "p", false, "count the keys with the prefix <begin>")
+	fs.StringVar(&c.countOpts.until, "until", "", "count until this key, inclusive")
+}
+
+// dump writes the range to a file in the binary dump format, the file is
+// removed if the dump fails
+func (c *command) dump(args []string) {
+	if len(args) > 1 {
+		c.printError(fmt.Errorf("at most one begin key is allowed"))
+		return
+	}
+	if c.dumpOpts.out == "" {
+		c.printError(fmt.Errorf("--out is required"))
+		return
+	}
+	begin, end, err := c.decodeRange(args, c.dumpOpts.prefix, c.dumpOpts.until)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	f, err := os.Create(c.dumpOpts.out)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	n, err := c.cli.Dump(f, begin, end)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(c.dumpOpts.out)
+		c.printError(err)
+		return
+	}
+	fmt.Println("Total dumped", n)
+}
+
+func (c *command) dumpFlags(fs *pflag.FlagSet) {
+	fs.BoolVarP(&c.dumpOpts.prefix, "prefix", "p", false, "dump the keys with the prefix <begin>")
+	fs.StringVar(&c.dumpOpts.until, "until", "", "dump until this key, inclusive")
+	fs.StringVar(&c.dumpOpts.out, "out", "", "file the pairs are written to")
+}
+
+// restore writes the pairs of a file written by dump, the whole file is
+// checked before anything is written so a partial file writes nothing
+func (c *command) restore(args []string) {
+	c.undo = nil
+	if c.restoreOpts.in == "" {
+		c.printError(fmt.Errorf("--in is required"))
+		return
+	}
+	f, err := os.Open(c.restoreOpts.in)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	defer f.Close()
+	if _, err := tikvclient.CheckDump(f); err != nil {
+		c.printError(fmt.Errorf("%s: %v", c.restoreOpts.in, err))
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		c.printError(err)
+		return
+	}
+	n, err := c.cli.Restore(f)
+	if err != nil {
+		c.printError(err)
+	}
+	fmt.Println("Total restored", n)
+}
+
+func (c *command) restoreFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.restoreOpts.in, "in", "", "file written by dump")
+}
+
+// load writes the pairs of the JSON lines of scan --output json in batches,
+// with --checkpoint the progress is saved after every committed batch and a
+// rerun resumes from it. The binary files of dump are read by restore.
+func (c *command) load(args []string) {
+	c.undo = nil
+	if len(args) != 1 {
+		c.printError(fmt.Errorf("file is required"))
+		return
+	}
+	if c.loadOpts.batch <= 0 {
+		c.printError(fmt.Errorf("batch should be greater than 0"))
+		return
+	}
+	// the batches are committed one by one
+	if c.cli.InTxn() {
+		c.printError(fmt.Errorf("load can not be used in a transaction, commit or rollback first"))
+		return
+	}
+	file := args[0]
+	cp := &loadCheckpoint{File: file}
+	if c.loadOpts.checkpoint != "" {
+		var err error
+		if cp, err = readCheckpoint(c.loadOpts.checkpoint, file); err != nil {
+			c.printError(err)
+			return
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+		c.printError(err)
+		return
+	}
+	if cp.Line > 0 {
+		fmt.Fprintf(os.Stderr, "resuming after line %d\n", cp.Line)
+	}
+
+	jr := newJSONLinesReader(f, cp.Offset, cp.Line)
+	var loaded int64
+	var batch []kvPair
+	commit := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := c.cli.SetMany(batch); err != nil {
+			return err
+		}
+		loaded += int64(len(batch))
+		for _, p := range batch {
+			c.fireHook("set", p.Key)
+		}
+		batch = batch[:0]
+		// every line before the offset is committed
+		cp.Offset, cp.Line = jr.offset, jr.line
+		if c.loadOpts.checkpoint != "" {
+			return cp.write(c.loadOpts.checkpoint)
+		}
+		return nil
+	}
+	for {
+		p, err := jr.next()
+		if err == io.EOF {
+			err = commit()
+		} else if err == nil {
+			batch = append(batch, *p)
+			if len(batch) < c.loadOpts.batch {
+				continue
+			}
+			if err = commit(); err == nil {
+				continue
 			}
 		}
-		fmt.Printf("%q:%q\n", string(key), string(val))
-		return true
-	})
+		if err != nil {
+			c.printError(err)
+		}
+		break
+	}
+	fmt.Println("Total loaded", loaded)
+}
+
+func (c *command) loadFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&c.loadOpts.batch, "batch", 256, "number of pairs written in one transaction")
+	fs.StringVar(&c.loadOpts.checkpoint, "checkpoint", "", "record the progress in this file after every batch and resume from it")
+}
+
+func (c *command) truncateFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.truncateOpts.prefix, "prefix", "", "delete the keys with this prefix")
+	fs.BoolVarP(&c.truncateOpts.yes, "yes", "y", false, "confirm the deletion, truncate refuses to run without it")
+	fs.IntVar(&c.truncateOpts.batch, "batch", 256, "number of keys deleted in one transaction")
+	fs.BoolVar(&c.truncateOpts.wholeKeyspace, "i-know-what-im-doing", false, "confirm the deletion of every key of the keyspace for an empty --prefix")
+}
+
+func (c *command) flushdbFlags(fs *pflag.FlagSet) {
+	fs.BoolVarP(&c.flushOpts.yes, "yes", "y", false, "do not ask for confirmation")
+	fs.IntVar(&c.flushOpts.batch, "batch", 256, "number of keys deleted in one transaction")
+}
+
+// confirmAffected asks for confirmation if the destructive operation affects
+// more keys than the threshold, count returns the number of the affected keys
+// and stops counting at max. The user has to type the number of the keys, so
+// the blast radius is read before it is confirmed.
+func (c *command) confirmAffected(what string, threshold int64, count func(max int64) (int64, error)) bool {
+	if c.opts.SkipPrecount {
+		return confirm(what + "?")
+	}
+	n, err := count(c.opts.PrecountCap + 1)
 	if err != nil {
-		fmt.Println(err)
+		c.printError(err)
+		return false
+	}
+	if n <= threshold {
+		return true
 	}
-	fmt.Println("Total scanned", count)
+	affected := strconv.FormatInt(n, 10)
+	if n > c.opts.PrecountCap {
+		// the counting stopped at the cap, which is what has to be typed
+		n = c.opts.PrecountCap
+		affected = fmt.Sprintf("more than %d", n)
+	}
+	fmt.Printf("%s, %s keys will be affected. Are you sure? type the number of keys to confirm: ", what, affected)
+	var answer string
+	fmt.Scanln(&answer)
+	return strings.TrimSpace(answer) == strconv.FormatInt(n, 10)
+}
+
+// confirm asks the user a yes/no question on the terminal
+func confirm(question string) bool {
+	fmt.Printf("%s (y/N) ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
 func cobraWapper(f func(args []string)) func(cmd *cobra.Command, args []string) {
@@ -119,19 +2134,130 @@ func cobraWapper(f func(args []string)) func(cmd *cobra.Command, args []string)
 
 func promptCompleter(d prompt.Document) []prompt.Suggest {
 	s := []prompt.Suggest{
-		{Text: "get", Description: "get <key1> [key2] [key3]..."},
+		{Text: "get", Description: "get <key1> [key2] [key3]..., get - to read the keys from stdin or get --prefix <p> [-n 10]"},
+		{Text: "mget", Description: "mget <key1> [key2] [key3]..., the same as get"},
 		{Text: "set", Description: "set <key> <val>"},
-		{Text: "delete", Description: "delete <key>"},
+		{Text: "set", Description: "set <key> --value-file <file>"},
+		{Text: "mset", Description: "mset <key1> <val1> [key2 val2]..."},
+		{Text: "incr", Description: "incr <key> [by]"},
+		{Text: "decr", Description: "decr <key> [by]"},
+		{Text: "delete", Description: "delete <key>..., delete - to read the keys from stdin or delete --prefix <p> --yes"},
 		{Text: "scan", Description: "scan -n 10 <begin>"},
 		{Text: "scan", Description: "scan -n 10 <begin> -d"},
+		{Text: "scan", Description: "scan -n 10 <begin> --snapshot <ts>"},
+		{Text: "rename", Description: "rename <src> <dst> [--prefix] [--overwrite]"},
+		{Text: "copy", Description: "copy <src> <dst> [--overwrite]"},
+		{Text: "select", Description: "select <db>"},
+		{Text: "flushdb", Description: "flushdb [-y] [--batch 256]"},
+		{Text: "truncate", Description: "truncate --prefix <p> --yes [--batch 256] [--i-know-what-im-doing]"},
+		{Text: "exists", Description: "exists <key1> [key2]..."},
+		{Text: "exists", Description: "exists --prefix <p> --against-file <keys> [--list]"},
+		{Text: "watch", Description: "watch <key> | --prefix <p> [--interval 1s]"},
+		{Text: "source", Description: "source [--continue-on-error] <file>"},
+		{Text: "bench", Description: "bench [--ops set,get,scan] [--keys 10000] [-c 16] [--value-size 64] [--keep]"},
+		{Text: "count", Description: "count [begin] [--prefix] [--until <key>] [-n 1000]"},
+		{Text: "load", Description: "load <file> [--batch 256] [--checkpoint <file>]"},
+		{Text: "dump", Description: "dump [begin] [--prefix] [--until <key>] --out <file>"},
+		{Text: "restore", Description: "restore --in <file>"},
+		{Text: "undo", Description: "revert the last set or delete of the session"},
+		{Text: "doctor", Description: "diagnose the connection to the cluster"},
+		{Text: "version", Description: "print the versions of the CLI, Go and the TiKV client"},
+		{Text: "info", Description: "print the PD addresses, the uuid and the current TSO of the cluster"},
+		{Text: "begin", Description: "start a transaction, the following commands run in it"},
+		{Text: "commit", Description: "commit the transaction"},
+		{Text: "rollback", Description: "discard the transaction"},
+		{Text: "set-opt", Description: "set-opt <option> <value>, e.g. set-opt output json"},
+		{Text: "show-opts", Description: "show the options of the session"},
 		{Text: "quit", Description: "quit the shell"},
 		{Text: "exit", Description: "quit the shell"},
 	}
 	return prompt.FilterHasPrefix(s, d.GetWordBeforeCursor(), true)
 }
 
-// hexEscape escape the hex literal to bytes
-func hexEscape(s string) string {
+// valueFlags registers the flags of the commands taking or returning values
+func (c *command) valueFlags(fs *pflag.FlagSet) {
+	c.inputFlags(fs)
+	fs.StringVar(&c.valueType, "type", "string", "type of the value: string, int or float, numbers are stored as canonical decimal text")
+}
+
+func (c *command) inputFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&c.inputOpts.hex, "input-hex", false, "decode the arguments as hex, overrides --key-encoding")
+	fs.BoolVar(&c.inputOpts.base64, "input-base64", false, "decode the arguments as base64, overrides --key-encoding")
+}
+
+// decodeArgs decodes the key and value arguments, the --input-* flags of the
+// command take precedence over the global --key-encoding
+func (c *command) decodeArgs(args []string) ([][]byte, error) {
+	if c.inputOpts.hex && c.inputOpts.base64 {
+		return nil, fmt.Errorf("--input-hex and --input-base64 are mutually exclusive")
+	}
+	encoding := c.opts.KeyEncoding
+	if c.inputOpts.hex {
+		encoding = "hex"
+	} else if c.inputOpts.base64 {
+		encoding = "base64"
+	}
+	decoded := make([][]byte, len(args))
+	for i, arg := range args {
+		var err error
+		if decoded[i], err = decodeArg(arg, encoding); err != nil {
+			return nil, fmt.Errorf("invalid %s argument %q: %v", encoding, arg, err)
+		}
+	}
+	return decoded, nil
+}
+
+// decodeKey decodes a key argument of the commands without --input-* flags,
+// like the range of scan, with the --key-encoding
+func (c *command) decodeKey(arg string) ([]byte, error) {
+	key, err := decodeArg(arg, c.opts.KeyEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s argument %q: %v", c.opts.KeyEncoding, arg, err)
+	}
+	return key, nil
+}
+
+// decodeArg decodes an argument with the encoding, escape is the hex escaped
+// literal like \x00
+func decodeArg(arg, encoding string) ([]byte, error) {
+	switch encoding {
+	case "hex":
+		return hex.DecodeString(arg)
+	case "base64":
+		return base64.StdEncoding.DecodeString(arg)
+	default:
+		s, err := hexEscape(arg)
+		return []byte(s), err
+	}
+}
+
+// typedValue validates the value against the type and returns its canonical
+// form, ints and floats are stored as their shortest decimal text without a
+// type tag so they stay readable by other clients
+func typedValue(val []byte, typ string) ([]byte, error) {
+	switch typ {
+	case "string":
+		return val, nil
+	case "int":
+		n, err := strconv.ParseInt(strings.TrimSpace(string(val)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an int", val)
+		}
+		return []byte(strconv.FormatInt(n, 10)), nil
+	case "float":
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(val)), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%q is not a finite float", val)
+		}
+		return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	default:
+		return nil, fmt.Errorf("unknown --type %q, expect string, int or float", typ)
+	}
+}
+
+// hexEscape escape the hex literal to bytes, an escape with invalid hex
+// digits is an error
+func hexEscape(s string) (string, error) {
 	escaped := make([]byte, len(s))
 	tune := false
 	j := 0
@@ -153,96 +2279,368 @@ func hexEscape(s string) string {
 			}
 			tune = false
 
+			// a truncated escape is kept literally
 			if i+2 >= len(s) {
-				escaped[j] = s[i]
-				j++
+				escaped[j], escaped[j+1] = '\\', s[i]
+				j += 2
 				continue
 			}
-			_, err := hex.Decode(escaped[j:], []byte(s[i+1:i+3]))
-			if err != nil {
-				log.Fatalln(err)
+			if _, err := hex.Decode(escaped[j:], []byte(s[i+1:i+3])); err != nil {
+				return "", fmt.Errorf("invalid escape \\x%s in %q", s[i+1:i+3], s)
 			}
 			i += 2
 			j++
 		default:
-			tune = false
+			// a backslash which starts no escape is kept literally
+			if tune {
+				tune = false
+				escaped[j] = '\\'
+				j++
+			}
 			escaped[j] = s[i]
 			j++
 		}
 	}
-	return string(escaped[0:j])
+	if tune {
+		escaped[j] = '\\'
+		j++
+	}
+	return string(escaped[0:j]), nil
 }
 
-func processLine(c *command, line string) {
-	args := strings.Split(line, " ")
+// processArgs runs a statement of the shell split into its arguments by
+// tokenize
+func processArgs(c *command, args []string) {
 	if len(args) == 0 {
 		return
 	}
 	cmd := args[0]
+	// every line parses its flags with a fresh flag set, so the options start
+	// from their defaults
+	parse := func(register func(fs *pflag.FlagSet)) ([]string, bool) {
+		fs := (&cobra.Command{}).Flags()
+		register(fs)
+		if err := fs.Parse(args[1:]); err != nil {
+			c.printError(err)
+			return nil, false
+		}
+		return fs.Args(), true
+	}
 	switch cmd {
-	case "get":
-		c.get(args[1:])
+	case "get", "mget":
+		if args, ok := parse(c.getFlags); ok {
+			c.get(args)
+		}
 	case "set":
-		c.set(args[1:])
+		if args, ok := parse(c.setFlags); ok {
+			c.set(args)
+		}
+	case "mset":
+		if args, ok := parse(c.valueFlags); ok {
+			c.mset(args)
+		}
 	case "delete":
-		c.delete(args[1:])
+		if args, ok := parse(c.deleteFlags); ok {
+			c.delete(args)
+		}
+	case "incr":
+		if args, ok := parse(c.inputFlags); ok {
+			c.incr(args)
+		}
+	case "decr":
+		if args, ok := parse(c.inputFlags); ok {
+			c.decr(args)
+		}
+	case "rename":
+		if args, ok := parse(c.renameFlags); ok {
+			c.rename(args)
+		}
+	case "copy":
+		if args, ok := parse(c.copyFlags); ok {
+			c.copyValue(args)
+		}
+	case "select":
+		c.selectDB(args[1:])
+	case "exists":
+		if args, ok := parse(c.existsFlags); ok {
+			c.exists(args)
+		}
+	case "watch":
+		if args, ok := parse(c.watchFlags); ok {
+			c.watch(args)
+		}
+	case "count":
+		if args, ok := parse(c.countFlags); ok {
+			c.count(args)
+		}
+	case "load":
+		if args, ok := parse(c.loadFlags); ok {
+			c.load(args)
+		}
+	case "dump":
+		if args, ok := parse(c.dumpFlags); ok {
+			c.dump(args)
+		}
+	case "restore":
+		if args, ok := parse(c.restoreFlags); ok {
+			c.restore(args)
+		}
+	case "doctor":
+		c.doctor(args[1:])
+	case "version":
+		c.printVersion(args[1:])
+	case "info":
+		c.info(args[1:])
+	case "undo":
+		c.undoLast(args[1:])
+	case "begin":
+		c.beginTxn(args[1:])
+	case "commit":
+		c.commitTxn(args[1:])
+	case "rollback":
+		c.rollbackTxn(args[1:])
+	case "set-opt":
+		c.setOpt(args[1:])
+	case "show-opts":
+		c.showOpts(args[1:])
+	case "flushdb":
+		if args, ok := parse(c.flushdbFlags); ok {
+			c.flushdb(args)
+		}
+	case "truncate":
+		if args, ok := parse(c.truncateFlags); ok {
+			c.truncate(args)
+		}
 	case "scan":
-		fs := (&cobra.Command{}).Flags()
-		fs.Int64VarP(&c.scanOpts.limit, "limit", "n", -1, "number of values to be scanned")
-		fs.BoolVarP(&c.scanOpts.prefix, "prefix", "p", false, "match with prefix")
-		fs.StringVarP(&c.scanOpts.until, "until", "u", "", "scan until match this key")
-		fs.BoolVarP(&c.scanOpts.delete, "delete", "d", false, "delete scanned keys")
-		if err := fs.Parse(args[1:]); err != nil {
-			fmt.Println(err)
+		if args, ok := parse(func(fs *pflag.FlagSet) { c.scanFlags(fs, "u") }); ok {
+			c.scan(args)
+		}
+	case "source":
+		if args, ok := parse(c.sourceFlags); ok {
+			c.source(args)
+		}
+	case "bench":
+		if args, ok := parse(c.benchFlags); ok {
+			c.bench(args)
 		}
-		c.scan(fs.Args())
 	default:
-		log.Println("unkown command", cmd)
+		c.printError(fmt.Errorf("unknown command %s", cmd))
 	}
 }
 
 func main() {
 	opts := &Options{}
-	c := &command{}
-
-	//log.SetFlags(0)
+	c := &command{opts: opts}
+	// a closed stdout fails the writes instead, see isBrokenPipe
+	signal.Ignore(syscall.SIGPIPE)
 
 	cmd := cobra.Command{Use: "tikv"}
-	cmd.PersistentFlags().StringVarP(&opts.Url, "url", "u", "", "tikv://etcd-node1:port,etcd-node2:port?cluster=1&disableGC=false")
+	cmd.PersistentFlags().StringVarP(&opts.Url, "url", "u", "", "tikv://etcd-node1:port,etcd-node2:port?cluster=1&disableGC=false (default: $TIKV_URL)")
+	cmd.PersistentFlags().StringVar(&opts.Config, "config", configPath(), "file of the default values of the options, by the names of their flags")
+	cmd.PersistentFlags().StringVarP(&opts.Output, "output", "o", "text", "output format of get and scan, text, json, ndjson-with-meta, csv, tsv, table, hex, base64 or raw")
+	cmd.PersistentFlags().StringVar(&opts.Output, "format", "text", "alias of --output")
+	cmd.PersistentFlags().BoolVar(&opts.JSONPretty, "json-pretty", false, "emit a pretty printed JSON array, all results are buffered in memory before printing")
+	cmd.PersistentFlags().BoolVar(&opts.JSONCompact, "json-compact", false, "emit one JSON object per line (default for --output json)")
+	cmd.PersistentFlags().StringVar(&opts.KeySplit, "key-split", "", "split keys into columns by this separator in scan output")
+	cmd.PersistentFlags().IntVar(&opts.KeyColumns, "key-columns", 0, "number of key columns for --key-split, extra parts are merged into the last column (default: parts of the first key)")
+	cmd.PersistentFlags().IntVar(&opts.DB, "db", -1, "logical database number, keys of each db are isolated under their own prefix (default: raw keyspace)")
+	cmd.PersistentFlags().StringVar(&opts.Namespace, "namespace", "", "prepend this prefix to every key and strip it from the keys printed, decoded like a key with --key-encoding")
+	cmd.PersistentFlags().StringVar(&opts.Template, "template", "", "render each key/value pair with a Go text/template, e.g. '{{.Key}}={{.Value}}'")
+	cmd.PersistentFlags().StringVar(&opts.TemplateFile, "template-file", "", "load the --template from a file")
+	cmd.PersistentFlags().Int64Var(&opts.ConfirmThreshold, "confirm-threshold", -1, "ask for confirmation only if a destructive operation affects more keys than this, -1 keeps the default behavior of each command")
+	cmd.PersistentFlags().Int64Var(&opts.PrecountCap, "precount-cap", 100000, "max number of keys counted for --confirm-threshold")
+	cmd.PersistentFlags().BoolVar(&opts.SkipPrecount, "skip-precount", false, "do not count the affected keys for --confirm-threshold, always ask instead")
+	cmd.PersistentFlags().IntVar(&opts.MaxConcurrentTxns, "max-concurrent-txns", 16, "max number of transactions the concurrent operations like scan --parallel and bench keep open at the same time, 0 for no limit")
+	cmd.PersistentFlags().IntVar(&opts.RegionErrorRetries, "retry-on-region-error", 0, "retry get, set and delete this many times after the client gave up on a region error")
+	cmd.PersistentFlags().BoolVarP(&opts.Verbose, "verbose", "v", false, "print diagnostics like the region error retries of every operation to stderr")
+	cmd.PersistentFlags().StringVar(&opts.LogLevel, "log-level", "", "print the logs of the TiKV client to stderr from this level: debug, info, warn, error or off (default: off, warn with --verbose)")
+	cmd.PersistentFlags().StringVar(&opts.BinaryCells, "binary-cells", "hex", "encoding of the keys and values of --output csv and tsv which are not text: hex, base64 or raw to keep the bytes")
+	cmd.PersistentFlags().StringVar(&opts.FormatError, "format-error", "text", "print the errors as text or as JSON objects with a stable code")
+	cmd.PersistentFlags().StringVar(&opts.ConnectMode, "connect-mode", tikvclient.ModeTxn, "use the transactional or the RawKV API: txn, raw or auto to probe the data")
+	cmd.PersistentFlags().BoolVar(&opts.NoHistory, "no-history", false, "do not save the lines of the shell to ~/.tikv-cli_history")
+	cmd.PersistentFlags().BoolVar(&opts.HexKeysInErrors, "hex-keys-in-errors", false, "render the keys of error and diagnostic messages as <hex:...>")
+	cmd.PersistentFlags().StringVar(&opts.KeyEncoding, "key-encoding", "escape", "encoding of the keys and values given to get, set, delete and scan: escape (\\x literals), hex or base64")
+	cmd.PersistentFlags().StringVar(&opts.KeyEncoding, "input", "escape", "alias of --key-encoding")
+	cmd.PersistentFlags().StringVar(&opts.MetaFields, "meta-fields", "key,value,value_len", "fields of --output ndjson-with-meta: "+strings.Join(metaFields, ","))
+	cmd.PersistentFlags().StringVar(&opts.CommitHook, "commit-hook", "", "shell command run after every successful set or delete, see TIKV_OP, TIKV_KEY and TIKV_KEY_HEX")
+	cmd.PersistentFlags().BoolVar(&opts.CommitHookSync, "commit-hook-sync", false, "wait for the commit hook to finish before the next command")
+	cmd.PersistentFlags().DurationVar(&opts.Timeout, "timeout", 10*time.Second, "give up on a request to the cluster after this long, 0 waits forever")
+	cmd.PersistentFlags().IntVar(&opts.ConnectRetries, "connect-retries", 0, "retry connecting this many times if the cluster is not reachable yet, e.g. while it starts")
+	cmd.PersistentFlags().DurationVar(&opts.ConnectBackoff, "connect-backoff", time.Second, "wait this long before the first connection retry, doubled after every retry up to 30s")
+	cmd.PersistentFlags().IntVar(&opts.MaxRetries, "max-retries", tikvclient.DefaultConflictRetries, "re-run set, delete, incr and the like this many times after a write conflict, with a backoff from 10ms doubled up to 500ms")
+	cmd.PersistentFlags().StringVar(&opts.MetricsAddr, "metrics-addr", "", "serve Prometheus metrics of the operations on http://<addr>/metrics, e.g. :9100 (default: not collected)")
+	cmd.PersistentFlags().BoolVar(&opts.NoColor, "no-color", false, "do not colorize the text output of get and scan on a terminal")
+	c.globalFlags = cmd.PersistentFlags()
 	cmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
-		cli, err := Dial(opts.Url)
+		if err := applyConfig(c.globalFlags, opts.Config, c.globalFlags.Changed("config")); err != nil {
+			c.printError(err)
+			os.Exit(c.exitCode)
+		}
+		if err := opts.validate(); err != nil {
+			c.printError(err)
+			os.Exit(c.exitCode)
+		}
+		tikvclient.HexKeysInErrors = opts.HexKeysInErrors
+		tikvclient.SetLogLevel(opts.logLevel())
+		// doctor reports the connection failures itself, and version does
+		// not need the cluster
+		if cmd.Name() == "doctor" || cmd.Name() == "version" {
+			return
+		}
+		if opts.Url == "" {
+			c.printError(fmt.Errorf("no cluster to connect to, set --url, %s or url in the config file", urlEnv))
+			os.Exit(c.exitCode)
+		}
+		cli, err := tikvclient.DialRetry(opts.Url, opts.ConnectMode, opts.ConnectRetries, opts.ConnectBackoff)
 		if err != nil {
-			log.Fatalln(err)
+			c.printError(err)
+			os.Exit(c.exitCode)
+		}
+		if opts.CommitHook != "" {
+			c.hook = newCommitHook(opts.CommitHook, opts.CommitHookSync, 64)
 		}
 		c.cli = cli
+		c.applyOpts()
+		if opts.MetricsAddr != "" {
+			if err := serveMetrics(opts.MetricsAddr, opts.Verbose); err != nil {
+				c.printError(err)
+				os.Exit(c.exitCode)
+			}
+			c.cli.SetMetrics(true)
+		}
+		if cmd != cmd.Root() {
+			// Ctrl-C stops waiting for the command, so it still cleans up
+			// like rolling back and waiting for the commit hooks
+			ctx, cancel := context.WithCancel(context.Background())
+			c.cli.SetContext(ctx)
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			go func() {
+				<-interrupt
+				signal.Stop(interrupt)
+				cancel()
+			}()
+		}
 	}
 	cmd.Run = func(cmd *cobra.Command, args []string) {
+		path := historyPath()
+		if opts.NoHistory {
+			path = ""
+		}
+		c.history = loadHistory(path)
 		for {
-			line := prompt.Input("> ", promptCompleter, prompt.OptionAddKeyBind(prompt.KeyBind{Key: prompt.ControlD, Fn: func(*prompt.Buffer) { os.Exit(0) }}))
+			prefix := "> "
+			if opts.DB >= 0 {
+				prefix = fmt.Sprintf("[%d]> ", opts.DB)
+			}
+			if len(opts.namespace) > 0 {
+				prefix = strings.TrimSuffix(prefix, "> ") + fmt.Sprintf("%q> ", opts.namespace)
+			}
+			if c.cli.InTxn() {
+				prefix = strings.TrimSuffix(prefix, "> ") + "(txn)> "
+			}
+			line := prompt.Input(prefix, c.complete, prompt.OptionHistory(c.history.lines),
+				prompt.OptionAddKeyBind(prompt.KeyBind{Key: prompt.ControlD, Fn: func(*prompt.Buffer) { c.close(); os.Exit(0) }}))
+			c.history.add(line)
 			if line == "exit" || line == "quit" {
+				c.close()
 				os.Exit(0)
 			}
-			processLine(c, line)
+			c.runInterruptible(line)
 		}
 	}
 
-	get := &cobra.Command{Use: "get <key>", Run: cobraWapper(c.get)}
+	get := &cobra.Command{Use: "get <key>", Aliases: []string{"mget"}, Run: cobraWapper(c.get)}
+	c.getFlags(get.Flags())
 	cmd.AddCommand(get)
 
 	set := &cobra.Command{Use: "set <key> <val>", Run: cobraWapper(c.set)}
+	c.setFlags(set.Flags())
 	cmd.AddCommand(set)
 
+	mset := &cobra.Command{Use: "mset <key1> <val1> [key2 val2]...", Short: "write the pairs atomically in one transaction", Run: cobraWapper(c.mset)}
+	c.valueFlags(mset.Flags())
+	cmd.AddCommand(mset)
+
+	incr := &cobra.Command{Use: "incr <key> [by]", Short: "add to the integer value of the key atomically", Run: cobraWapper(c.incr)}
+	c.inputFlags(incr.Flags())
+	cmd.AddCommand(incr)
+
+	decr := &cobra.Command{Use: "decr <key> [by]", Short: "subtract from the integer value of the key atomically", Run: cobraWapper(c.decr)}
+	c.inputFlags(decr.Flags())
+	cmd.AddCommand(decr)
+
 	scan := &cobra.Command{Use: "scan <begin>", Run: cobraWapper(c.scan)}
-	scan.Flags().Int64VarP(&c.scanOpts.limit, "limit", "n", -1, "number of values to be scanned")
-	scan.Flags().BoolVarP(&c.scanOpts.prefix, "prefix", "p", false, "match with prefix")
-	scan.Flags().StringVarP(&c.scanOpts.until, "until", "U", "", "scan until match this key")
-	scan.Flags().BoolVarP(&c.scanOpts.delete, "delete", "d", false, "delete scanned keys")
+	c.scanFlags(scan.Flags(), "U")
 	cmd.AddCommand(scan)
 
 	delete := &cobra.Command{Use: "delete <key>", Run: cobraWapper(c.delete)}
+	c.deleteFlags(delete.Flags())
 	cmd.AddCommand(delete)
 
+	rename := &cobra.Command{Use: "rename <src> <dst>", Short: "rename a key or a prefix", Run: cobraWapper(c.rename)}
+	c.renameFlags(rename.Flags())
+	cmd.AddCommand(rename)
+
+	copyCmd := &cobra.Command{Use: "copy <src> <dst>", Short: "copy the value of a key to another key", Run: cobraWapper(c.copyValue)}
+	c.copyFlags(copyCmd.Flags())
+	cmd.AddCommand(copyCmd)
+
+	flushdb := &cobra.Command{Use: "flushdb", Short: "delete all the keys of the db selected by --db", Run: cobraWapper(c.flushdb)}
+	c.flushdbFlags(flushdb.Flags())
+	cmd.AddCommand(flushdb)
+
+	truncate := &cobra.Command{Use: "truncate", Short: "delete all the keys with the --prefix in batched transactions", Run: cobraWapper(c.truncate)}
+	c.truncateFlags(truncate.Flags())
+	cmd.AddCommand(truncate)
+
+	exists := &cobra.Command{Use: "exists [key]...", Short: "check which keys exist, with a single scan of the --prefix if given", Run: cobraWapper(c.exists)}
+	c.existsFlags(exists.Flags())
+	cmd.AddCommand(exists)
+
+	watch := &cobra.Command{Use: "watch [key]", Short: "poll a key or the keys of a --prefix and print their changes", Run: cobraWapper(c.watch)}
+	c.watchFlags(watch.Flags())
+	cmd.AddCommand(watch)
+
+	source := &cobra.Command{Use: "source file", Short: "run the lines of a script file like the lines of the shell", Run: cobraWapper(c.source)}
+	c.sourceFlags(source.Flags())
+	cmd.AddCommand(source)
+
+	bench := &cobra.Command{Use: "bench", Short: "measure the throughput and the latencies of set, get and scan with random keys", Run: cobraWapper(c.bench)}
+	c.benchFlags(bench.Flags())
+	cmd.AddCommand(bench)
+
+	count := &cobra.Command{Use: "count [begin]", Short: "print the number of keys in the range", Run: cobraWapper(c.count)}
+	c.countFlags(count.Flags())
+	cmd.AddCommand(count)
+
+	load := &cobra.Command{Use: "load <file>", Short: "write the pairs of the JSON lines from scan --output json", Run: cobraWapper(c.load)}
+	c.loadFlags(load.Flags())
+	cmd.AddCommand(load)
+
+	dump := &cobra.Command{Use: "dump [begin]", Short: "write the range to a binary dump file", Run: cobraWapper(c.dump)}
+	c.dumpFlags(dump.Flags())
+	cmd.AddCommand(dump)
+
+	restore := &cobra.Command{Use: "restore", Short: "write the pairs of a file written by dump", Run: cobraWapper(c.restore)}
+	c.restoreFlags(restore.Flags())
+	cmd.AddCommand(restore)
+
+	doctor := &cobra.Command{Use: "doctor", Short: "diagnose the connection to the cluster", Run: cobraWapper(c.doctor)}
+	cmd.AddCommand(doctor)
+
+	versionCmd := &cobra.Command{Use: "version", Short: "print the versions of the CLI, Go and the TiKV client", Run: cobraWapper(c.printVersion)}
+	cmd.AddCommand(versionCmd)
+
+	info := &cobra.Command{Use: "info", Short: "print the PD addresses, the uuid and the current TSO of the cluster", Run: cobraWapper(c.info)}
+	cmd.AddCommand(info)
+	cmd.Version = Version().String()
+	cmd.SetVersionTemplate("{{.Version}}\n")
+
 	if err := cmd.Execute(); err != nil {
-		log.Fatal(err)
+		c.printError(err)
+		os.Exit(c.exitCode)
 	}
+	c.close()
+	os.Exit(c.exitCode)
 }
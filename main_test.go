@@ -0,0 +1,640 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb/kv"
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// newTestCommand returns a command with the default options on top of an
+// empty mem store
+func newTestCommand(t *testing.T) *command {
+	opts := &Options{
+		Output:            "text",
+		KeyEncoding:       "escape",
+		FormatError:       "text",
+		ConnectMode:       tikvclient.ModeTxn,
+		BinaryCells:       "hex",
+		MetaFields:        "key,value,value_len",
+		DB:                -1,
+		ConfirmThreshold:  -1,
+		PrecountCap:       100000,
+		MaxConcurrentTxns: 16,
+		NoColor:           true,
+	}
+	if err := opts.validate(); err != nil {
+		t.Fatal(err)
+	}
+	c := &command{opts: opts}
+	c.cli = tikvclient.NewClient(tikvclient.NewMemStore())
+	return c
+}
+
+// capture returns what fn printed to stdout
+func capture(t *testing.T, fn func()) string {
+	return redirect(t, &os.Stdout, fn)
+}
+
+// captureStderr returns what fn printed to stderr
+func captureStderr(t *testing.T, fn func()) string {
+	return redirect(t, &os.Stderr, fn)
+}
+
+// redirect returns what fn wrote to the file, which is a pipe meanwhile
+func redirect(t *testing.T, f **os.File, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *f
+	*f = w
+	out := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- data
+	}()
+	defer func() {
+		*f = saved
+	}()
+	fn()
+	w.Close()
+	return string(<-out)
+}
+
+// run runs the shell statements and fails the test on any error
+func run(t *testing.T, c *command, lines ...string) {
+	for _, line := range lines {
+		failures := c.failures
+		runLine(c, line)
+		if c.failures != failures {
+			t.Fatalf("%s failed", line)
+		}
+	}
+}
+
+// mustFail runs the statement, which has to fail, and drops its output
+func mustFail(t *testing.T, c *command, line string) {
+	failures := c.failures
+	captureStderr(t, func() {
+		capture(t, func() { runLine(c, line) })
+	})
+	if c.failures == failures {
+		t.Fatalf("%s did not fail", line)
+	}
+}
+
+// output runs the statement like run and returns its stdout, its stderr like
+// the summary lines of scan is dropped
+func output(t *testing.T, c *command, line string) string {
+	var out string
+	captureStderr(t, func() {
+		out = capture(t, func() { run(t, c, line) })
+	})
+	return out
+}
+
+// mustGet returns the value of the key, nil if it is missing
+func mustGet(t *testing.T, c *command, key string) []byte {
+	val, err := c.cli.Get([]byte(key))
+	if tikvclient.CodeOf(err) == tikvclient.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return val
+}
+
+func TestScanBadFlagDeletesNothing(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "set p1 1", "set p2 2")
+	// typos of --dry-run and --match must not run a plain scan -d
+	for _, line := range []string{"scan p -p -d --dryrun", "scan p -p -d --mach 1"} {
+		mustFail(t, c, line)
+		for _, key := range []string{"p1", "p2"} {
+			if mustGet(t, c, key) == nil {
+				t.Fatalf("%s deleted %s", line, key)
+			}
+		}
+	}
+}
+
+func TestScanExecDelete(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "set k1 1")
+	mustFail(t, c, "scan k -p -d --exec false")
+	if mustGet(t, c, "k1") == nil {
+		t.Fatal("k1 was deleted")
+	}
+}
+
+func TestScanCount(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "set a1 1", "set a2 2", "set a3 3", "set b1 4", "set b2 5")
+	for _, s := range []struct {
+		line, stdout, total string
+	}{
+		// --until is inclusive and b1 is never counted
+		{"scan a1 --until a2", "\"a1\":\"1\"\n\"a2\":\"2\"\n", "Total scanned 2\n"},
+		// the first key out of the prefix ends the scan
+		{"scan a -p", "\"a1\":\"1\"\n\"a2\":\"2\"\n\"a3\":\"3\"\n", "Total scanned 3\n"},
+		{"scan a -p --count-only", "3\n", ""},
+		{"scan a1 --until a2 --count-only", "2\n", ""},
+		{"scan b -p --count-only", "2\n", ""},
+		{"scan c -p --count-only", "0\n", ""},
+		// the pairs skipped by a filter are not counted
+		{"scan a -p --match [13]$", "\"a1\":\"1\"\n\"a3\":\"3\"\n", "Total scanned 2\n"},
+		{"scan a -p --match [13]$ --count-only", "2\n", ""},
+	} {
+		var stdout string
+		stderr := captureStderr(t, func() {
+			stdout = capture(t, func() { run(t, c, s.line) })
+		})
+		if stdout != s.stdout || stderr != s.total {
+			t.Errorf("%s: got %q and %q on stderr, want %q and %q", s.line, stdout, stderr, s.stdout, s.total)
+		}
+	}
+}
+
+func TestGetPrefixResetsScanOptions(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "set a1 1", "set a2 2", "set b1 3")
+	output(t, c, "scan a -p -n 1 --keys-only --match 2$")
+	if out := output(t, c, "get --prefix a"); out != "\"a1\":\"1\"\n\"a2\":\"2\"\n" {
+		t.Fatalf("got %q, the options of the previous scan leaked", out)
+	}
+	if c.scanOpts.parallel != 1 || c.scanOpts.keysOutEncoding != "escape" {
+		t.Fatalf("the defaults of the scan flags were not restored: %+v", c.scanOpts)
+	}
+}
+
+// withStdin runs fn with stdin reading the input
+func withStdin(t *testing.T, input string, fn func()) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		r.Close()
+	}()
+	fn()
+}
+
+func TestDeletePrefix(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "set p1 1", "set p2 2", "set q 3")
+
+	// over the threshold the number of keys has to be typed
+	c.opts.ConfirmThreshold = 1
+	withStdin(t, "3\n", func() {
+		capture(t, func() { run(t, c, "delete --prefix p --yes") })
+	})
+	if mustGet(t, c, "p1") == nil {
+		t.Fatal("the deletion was not confirmed")
+	}
+
+	// the prefix follows --key-encoding like the one of truncate
+	c.opts.KeyEncoding = "hex"
+	withStdin(t, "2\n", func() {
+		capture(t, func() { run(t, c, "delete --prefix 70 --yes") })
+	})
+	if mustGet(t, c, "p1") != nil || mustGet(t, c, "p2") != nil || mustGet(t, c, "q") == nil {
+		t.Fatal("delete --prefix did not delete exactly the keys of p")
+	}
+	mustFail(t, c, "delete --prefix 71 --yes --input-hex")
+}
+
+// failingStore returns transactions whose iterators fail on the failAt-th
+// Next, 0 for never. Like the scanner of TiKV which loads the first key by
+// calling Next, a seek is the first Next.
+type failingStore struct {
+	tikvclient.Store
+	failAt int
+}
+
+func (s *failingStore) Begin() (kv.Transaction, error) {
+	txn, err := s.Store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &failingTxn{Transaction: txn, failAt: s.failAt}, nil
+}
+
+type failingTxn struct {
+	kv.Transaction
+	failAt int
+}
+
+func (txn *failingTxn) Seek(k kv.Key) (kv.Iterator, error) {
+	iter := &failingIter{failAt: txn.failAt}
+	if err := iter.step(); err != nil {
+		return nil, err
+	}
+	var err error
+	iter.Iterator, err = txn.Transaction.Seek(k)
+	return iter, err
+}
+
+type failingIter struct {
+	kv.Iterator
+	nexts  int
+	failAt int
+}
+
+// step counts a Next and fails the failAt-th one
+func (iter *failingIter) step() error {
+	if iter.nexts++; iter.nexts == iter.failAt {
+		return errors.New("injected iterator failure")
+	}
+	return nil
+}
+
+func (iter *failingIter) Next() error {
+	if err := iter.step(); err != nil {
+		return err
+	}
+	return iter.Iterator.Next()
+}
+
+func TestScanIteratorFailure(t *testing.T) {
+	store := &failingStore{Store: tikvclient.NewMemStore()}
+	c := newTestCommand(t)
+	c.cli = tikvclient.NewClient(store)
+	run(t, c, "set k1 1", "set k2 2", "set k3 3", "set k4 4")
+
+	store.failAt = 3
+	var out string
+	stderr := captureStderr(t, func() {
+		out = capture(t, func() { runLine(c, "scan k -p") })
+	})
+	if out != "\"k1\":\"1\"\n\"k2\":\"2\"\n" {
+		t.Fatalf("got %q", out)
+	}
+	for _, line := range []string{
+		"injected iterator failure",
+		"warning: scan is incomplete, the last emitted key is \"k2\"",
+		"Total scanned 2",
+	} {
+		if !strings.Contains(stderr, line) {
+			t.Fatalf("no %q in %q", line, stderr)
+		}
+	}
+
+	// the keys visited before the failure are not emitted by the filters
+	for _, f := range []struct {
+		line, warning string
+	}{
+		{"scan k -p --match 1$", "the last emitted key is \"k1\""},
+		{"scan k -p --match 4$", "no key was emitted, the last scanned key is \"k2\""},
+	} {
+		stderr := captureStderr(t, func() {
+			capture(t, func() { runLine(c, f.line) })
+		})
+		if !strings.Contains(stderr, "warning: scan is incomplete, "+f.warning) {
+			t.Errorf("%s: no %q in %q", f.line, f.warning, stderr)
+		}
+	}
+	store.failAt = 1
+	stderr = captureStderr(t, func() {
+		capture(t, func() { runLine(c, "scan k -p --match 4$") })
+	})
+	if !strings.Contains(stderr, "warning: scan is incomplete, no key was scanned") {
+		t.Fatalf("got %q", stderr)
+	}
+	store.failAt = 3
+
+	n, err := c.cli.Scan([]byte("k"), -1, nil, func(key, val []byte) bool { return true })
+	if err == nil || n != 2 {
+		t.Fatalf("got %d, %v, want 2 keys and the failure", n, err)
+	}
+}
+
+func TestDecodeArgsPrecedence(t *testing.T) {
+	c := newTestCommand(t)
+	for _, d := range []struct {
+		encoding   string
+		hex, b64   bool
+		arg, want  string
+		shouldFail bool
+	}{
+		{encoding: "escape", arg: `k\x01`, want: "k\x01"},
+		{encoding: "escape", hex: true, arg: "6b01", want: "k\x01"},
+		{encoding: "escape", b64: true, arg: "awE=", want: "k\x01"},
+		{encoding: "hex", arg: "6b01", want: "k\x01"},
+		{encoding: "hex", b64: true, arg: "awE=", want: "k\x01"},
+		{encoding: "base64", arg: "awE=", want: "k\x01"},
+		{encoding: "base64", hex: true, arg: "6b01", want: "k\x01"},
+		{encoding: "base64", hex: true, arg: "awE=", shouldFail: true},
+		{encoding: "escape", hex: true, b64: true, arg: "6b01", shouldFail: true},
+	} {
+		c.opts.KeyEncoding = d.encoding
+		c.inputOpts.hex, c.inputOpts.base64 = d.hex, d.b64
+		args, err := c.decodeArgs([]string{d.arg})
+		if d.shouldFail {
+			if err == nil {
+				t.Errorf("%+v: decoded %q", d, args[0])
+			}
+			continue
+		}
+		if err != nil || string(args[0]) != d.want {
+			t.Errorf("%+v: got %q, %v", d, args, err)
+		}
+	}
+
+	// the flags of a statement apply to it alone
+	c.opts.KeyEncoding = "base64"
+	run(t, c, "set --input-hex 6b 76", "set awE= dg==")
+	if string(mustGet(t, c, "k")) != "v" || string(mustGet(t, c, "k\x01")) != "v" {
+		t.Fatal("the --input-hex of set leaked into the next statement")
+	}
+}
+
+func TestDeleteReportsExisting(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "set k1 1", "set k3 3")
+	want := "\"k1\": deleted\n\"k2\": not found\n\"k3\": deleted\n(integer) 2\n"
+	if out := output(t, c, "delete k1 k2 k3"); out != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+}
+
+// scanPages runs the scan page by page, following the cursors, and returns
+// the keys of every page
+func scanPages(t *testing.T, c *command, line string) [][]string {
+	defer func(output string) { c.opts.Output = output }(c.opts.Output)
+	c.opts.Output = "raw"
+	var pages [][]string
+	cursor := ""
+	for len(pages) < 100 {
+		statement := line
+		if cursor != "" {
+			statement += " --cursor " + cursor
+		}
+		var out string
+		stderr := captureStderr(t, func() {
+			out = capture(t, func() { run(t, c, statement) })
+		})
+		var keys []string
+		for _, l := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+			if l != "" {
+				keys = append(keys, strings.SplitN(l, "\t", 2)[0])
+			}
+		}
+		pages = append(pages, keys)
+		cursor = ""
+		for _, l := range strings.Split(stderr, "\n") {
+			if strings.HasPrefix(l, "Next cursor ") {
+				cursor = strings.TrimPrefix(l, "Next cursor ")
+			}
+		}
+		if cursor == "" {
+			return pages
+		}
+	}
+	t.Fatalf("%s never ended", line)
+	return nil
+}
+
+func TestReverseScanPagination(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "set a0 0", "set k1 1", "set k2 2", "set k3 3", "set k4 4", "set k5 5")
+	want := "[k5 k4 k3 k2 k1 a0]"
+	for size := 1; size <= 7; size++ {
+		pages := scanPages(t, c, fmt.Sprintf("scan '' --reverse --page-size %d", size))
+		var keys []string
+		for i, page := range pages {
+			if len(page) > size || i < len(pages)-1 && len(page) != size {
+				t.Fatalf("page size %d: got the pages %v", size, pages)
+			}
+			keys = append(keys, page...)
+		}
+		// no gap and no duplicate
+		if fmt.Sprint(keys) != want {
+			t.Fatalf("page size %d: got %v, want %s", size, keys, want)
+		}
+		// a full page ending on the first key has a cursor before it, which
+		// gives an empty page without any cursor
+		if 6%size == 0 && len(pages[len(pages)-1]) != 0 {
+			t.Fatalf("page size %d: got the pages %v", size, pages)
+		}
+	}
+
+	// a cursor on the first key continues before it, with nothing left
+	c.opts.Output = "raw"
+	if out := output(t, c, "scan '' --reverse --page-size 2 --cursor "+encodeCursor([]byte("a0"), true)); out != "" {
+		t.Fatalf("got %q before the first key", out)
+	}
+	if out := output(t, c, "scan '' --reverse --page-size 2 --cursor "+encodeCursor([]byte("k1"), true)); out != "a0\t0\n" {
+		t.Fatalf("got %q before k1", out)
+	}
+}
+
+func TestTypedValues(t *testing.T) {
+	for _, v := range []struct {
+		typ, val, want string
+	}{
+		{"string", " 042 ", " 042 "},
+		{"int", " 042", "42"},
+		{"int", "-7", "-7"},
+		{"int", "abc", ""},
+		{"int", "4.2", ""},
+		{"int", "", ""},
+		{"int", "99999999999999999999", ""},
+		{"float", "1.50", "1.5"},
+		{"float", "1e3", "1000"},
+		{"float", "NaN", ""},
+		{"float", "Inf", ""},
+		{"float", "1.5x", ""},
+		{"bool", "true", ""},
+	} {
+		got, err := typedValue([]byte(v.val), v.typ)
+		if v.want == "" {
+			if err == nil {
+				t.Errorf("%s %q was accepted as %q", v.typ, v.val, got)
+			}
+			continue
+		}
+		if err != nil || string(got) != v.want {
+			t.Errorf("%s %q: got %q, %v, want %q", v.typ, v.val, got, err, v.want)
+		}
+	}
+
+	c := newTestCommand(t)
+	mustFail(t, c, "set --type int n abc")
+	if mustGet(t, c, "n") != nil {
+		t.Fatal("a malformed int was stored")
+	}
+	run(t, c, "set --type int n ' 042'", "set s abc")
+	if val := mustGet(t, c, "n"); string(val) != "42" {
+		t.Fatalf("got %q, want the canonical 42", val)
+	}
+	if out := output(t, c, "get --type int n"); out != "\"n\"\n\"42\"\n" {
+		t.Fatalf("got %q", out)
+	}
+	mustFail(t, c, "get --type int s")
+}
+
+func TestSizeWarningBoundaries(t *testing.T) {
+	for _, s := range []struct {
+		key, val int
+		want     int
+	}{
+		{1024, 1 << 20, 0},
+		{1025, 1 << 20, 1},
+		{1024, 1<<20 + 1, 1},
+		{1025, 1<<20 + 1, 2},
+		{0, 0, 0},
+	} {
+		warnings := sizeWarnings(make([]byte, s.key), make([]byte, s.val), 1024, 1<<20)
+		if len(warnings) != s.want {
+			t.Errorf("key %d, value %d bytes: got %q", s.key, s.val, warnings)
+		}
+	}
+
+	c := newTestCommand(t)
+	for _, w := range []struct {
+		line string
+		warn bool
+	}{
+		{"set --warn-value-size 3 k abc", false},
+		{"set --warn-value-size 3 k abcd", true},
+		{"set --warn-key-size 2 --no-warn key abcd", false},
+	} {
+		stderr := captureStderr(t, func() {
+			capture(t, func() { run(t, c, w.line) })
+		})
+		if strings.Contains(stderr, "warning:") != w.warn {
+			t.Errorf("%s: got %q on stderr", w.line, stderr)
+		}
+	}
+	// the pair is written anyway
+	if string(mustGet(t, c, "k")) != "abcd" || string(mustGet(t, c, "key")) != "abcd" {
+		t.Fatal("a warned pair was not written")
+	}
+}
+
+func TestGetPreview(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "set big 0123456789", "set small 012")
+	if out := output(t, c, "get --preview 4 big"); out != "\"big\"\n\"0123\"\n(showing 4 of 10 bytes)\n" {
+		t.Fatalf("got %q", out)
+	}
+	// a value not larger than N is displayed whole without a note
+	if out := output(t, c, "get --preview 3 small"); out != "\"small\"\n\"012\"\n" {
+		t.Fatalf("got %q", out)
+	}
+	// the value itself is not truncated
+	if string(mustGet(t, c, "big")) != "0123456789" {
+		t.Fatal("the previewed value was changed")
+	}
+}
+
+func TestUndo(t *testing.T) {
+	c := newTestCommand(t)
+	output(t, c, "set k old")
+	output(t, c, "set k new")
+	if out := output(t, c, "undo"); out != "undo set: restored \"k\" to \"old\"\n" {
+		t.Fatalf("got %q", out)
+	}
+	if val := mustGet(t, c, "k"); string(val) != "old" {
+		t.Fatalf("got %q after undoing the overwrite, want old", val)
+	}
+	output(t, c, "set fresh v")
+	if out := output(t, c, "undo"); out != "undo set: deleted \"fresh\"\n" {
+		t.Fatalf("got %q", out)
+	}
+	if val := mustGet(t, c, "fresh"); val != nil {
+		t.Fatalf("got %q after undoing the set of an absent key", val)
+	}
+	output(t, c, "delete k")
+	if out := output(t, c, "undo"); out != "undo delete: restored \"k\" to \"old\"\n" {
+		t.Fatalf("got %q", out)
+	}
+	if val := mustGet(t, c, "k"); string(val) != "old" {
+		t.Fatalf("got %q after undoing the delete, want old", val)
+	}
+	// there is a single level of undo
+	mustFail(t, c, "undo")
+}
+
+func TestHexEscape(t *testing.T) {
+	for _, e := range []struct {
+		in, want string
+		invalid  bool
+	}{
+		{`val`, "val", false},
+		{`\x00\xff`, "\x00\xff", false},
+		{`a\x41b`, "aAb", false},
+		{`val\x`, `val\x`, false},
+		{`\x1`, `\x1`, false},
+		{`\x`, `\x`, false},
+		{`\xG0`, "", true},
+		{`\x0G`, "", true},
+		{`a\\x41`, `a\x41`, false},
+		{`a\\`, `a\`, false},
+		{`a\b`, `a\b`, false},
+		{`val\`, `val\`, false},
+		{`\`, `\`, false},
+		{`x`, "x", false},
+	} {
+		got, err := hexEscape(e.in)
+		if e.invalid {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", e.in, got)
+			}
+			continue
+		}
+		if err != nil || got != e.want {
+			t.Errorf("%s: got %q, %v, want %q", e.in, got, err, e.want)
+		}
+	}
+
+	c := newTestCommand(t)
+	run(t, c, `set k val\x`)
+	if val := mustGet(t, c, "k"); string(val) != `val\x` {
+		t.Fatalf("got %q", val)
+	}
+	mustFail(t, c, `set k \xG0`)
+}
+
+func TestBase64NulRoundTrip(t *testing.T) {
+	key, val := []byte("k\x00\\x00"), []byte("\x00v\x00\xff")
+	for _, encoding := range []string{"escape", "hex", "base64"} {
+		for _, data := range [][]byte{key, val} {
+			got, err := decodeArg(encodeLine(data, encoding), encoding)
+			if err != nil || string(got) != string(data) {
+				t.Errorf("%s: got %q, %v, want %q", encoding, got, err, data)
+			}
+		}
+	}
+
+	c := newTestCommand(t)
+	c.opts.KeyEncoding = "base64"
+	c.opts.Output = "base64"
+	k, v := encodeLine(key, "base64"), encodeLine(val, "base64")
+	run(t, c, "set "+k+" "+v)
+	// the arguments are not hex escaped once decoded
+	if got := mustGet(t, c, string(key)); string(got) != string(val) {
+		t.Fatalf("got %q, want %q", got, val)
+	}
+	if out := output(t, c, "get "+k); out != k+"\t"+v+"\n" {
+		t.Fatalf("got %q", out)
+	}
+	output(t, c, "delete "+k)
+	if mustGet(t, c, string(key)) != nil {
+		t.Fatal("the key was not deleted")
+	}
+}
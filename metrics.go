@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serveMetrics serves the metrics of the default registry, the ones of the
+// operations and of the TiKV client, on http://addr/metrics in the background
+// for as long as the process runs
+func serveMetrics(addr string, verbose bool) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("can not serve the metrics: %v", err)
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "serving the metrics on http://%s/metrics\n", l.Addr())
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.UninstrumentedHandler())
+	go http.Serve(l, mux)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape returns the samples of the metrics endpoint by name and labels
+func scrape(t *testing.T, url string) map[string]float64 {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make(map[string]float64)
+	for _, line := range strings.Split(string(body), "\n") {
+		i := strings.LastIndexByte(line, ' ')
+		if strings.HasPrefix(line, "#") || i < 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+			samples[line[:i]] = v
+		}
+	}
+	return samples
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	var url string
+	stderr := captureStderr(t, func() {
+		if err := serveMetrics("127.0.0.1:0", true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if i := strings.Index(stderr, "http://"); i >= 0 {
+		url = strings.TrimSpace(stderr[i:])
+	} else {
+		t.Fatalf("got %q, want the address of the endpoint", stderr)
+	}
+
+	c := newTestCommand(t)
+	c.cli.SetMetrics(true)
+	// the metrics are process wide, only their increase is checked
+	before := scrape(t, url)
+	output(t, c, "set k v")
+	output(t, c, "set k2 v2")
+	output(t, c, "get k")
+	output(t, c, "get missing")
+	mustGet(t, c, "missing")
+	after := scrape(t, url)
+
+	for _, m := range []struct {
+		sample string
+		inc    float64
+	}{
+		{`tikv_cli_operations_total{op="set",result="ok"}`, 2},
+		{`tikv_cli_operations_total{op="get",result="ok"}`, 2},
+		{`tikv_cli_operations_total{op="get",result="not_found"}`, 1},
+		{`tikv_cli_operation_duration_seconds_count{op="set"}`, 2},
+		{`tikv_cli_written_bytes_total`, 6},
+		{`tikv_cli_read_bytes_total`, 2},
+	} {
+		if _, ok := after[m.sample]; !ok {
+			t.Errorf("%s is not served", m.sample)
+			continue
+		}
+		if inc := after[m.sample] - before[m.sample]; inc != m.inc {
+			t.Errorf("%s increased by %v, want %v", m.sample, inc, m.inc)
+		}
+	}
+
+	// nothing is collected once the metrics are off
+	c.cli.SetMetrics(false)
+	output(t, c, "set k v")
+	set := `tikv_cli_operations_total{op="set",result="ok"}`
+	if s := scrape(t, url); s[set] != after[set] {
+		t.Fatalf("%s increased with the metrics off", set)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+	"github.com/spf13/pflag"
+)
+
+// fixedOpts are the global options used to connect or to start the shell,
+// they can not be changed by set-opt once it is started
+var fixedOpts = map[string]bool{
+	"url":              true,
+	"connect-mode":     true,
+	"commit-hook":      true,
+	"commit-hook-sync": true,
+	"no-history":       true,
+	"config":           true,
+	"connect-retries":  true,
+	"connect-backoff":  true,
+	"metrics-addr":     true,
+}
+
+// applyOpts hands the global options over to the client
+func (c *command) applyOpts() {
+	tikvclient.HexKeysInErrors = c.opts.HexKeysInErrors
+	tikvclient.SetLogLevel(c.opts.logLevel())
+	c.cli.SetKeyspace(c.keyspace())
+	c.cli.SetRegionErrorRetries(c.opts.RegionErrorRetries)
+	c.cli.SetConflictRetries(c.opts.MaxRetries)
+	c.cli.SetVerbose(c.opts.Verbose)
+	c.cli.SetMaxConcurrentTxns(c.opts.MaxConcurrentTxns)
+	c.cli.SetTimeout(c.opts.Timeout)
+}
+
+// keyspace returns the prefix of the keys of the current db and namespace,
+// nil for the raw keyspace
+func (c *command) keyspace() []byte {
+	var keyspace []byte
+	if c.opts.DB >= 0 {
+		keyspace = tikvclient.DBKeyspace(c.opts.DB)
+	}
+	return append(keyspace, c.opts.namespace...)
+}
+
+// setOpt changes a global option for the rest of the session, the value is
+// parsed and validated like the command line flag and reverted if invalid
+func (c *command) setOpt(args []string) {
+	if len(args) < 2 {
+		c.printError(fmt.Errorf("option name and value are required"))
+		return
+	}
+	name := strings.TrimPrefix(args[0], "--")
+	f := c.globalFlags.Lookup(name)
+	if f == nil {
+		c.printError(fmt.Errorf("unknown option %q, see show-opts", name))
+		return
+	}
+	if fixedOpts[name] {
+		c.printError(fmt.Errorf("--%s is used when starting the shell and can not be changed in it", name))
+		return
+	}
+	// the line is split on spaces, so a template may span several arguments
+	value := strings.Join(args[1:], " ")
+	old := f.Value.String()
+	keyspace := c.keyspace()
+	if err := f.Value.Set(value); err != nil {
+		c.printError(fmt.Errorf("invalid value %q of --%s: %v", value, name, err))
+		return
+	}
+	if err := c.opts.validate(); err != nil {
+		f.Value.Set(old)
+		c.opts.validate()
+		c.printError(err)
+		return
+	}
+	if !bytes.Equal(keyspace, c.keyspace()) {
+		// the keys of the undo record belong to the previous db or namespace
+		c.undo = nil
+	}
+	c.applyOpts()
+}
+
+// showOpts prints the current value of every global option
+func (c *command) showOpts(args []string) {
+	c.globalFlags.VisitAll(func(f *pflag.Flag) {
+		value := f.Value.String()
+		if f.Value.Type() == "string" {
+			value = fmt.Sprintf("%q", value)
+		}
+		if fixedOpts[f.Name] {
+			value += " (fixed)"
+		}
+		fmt.Printf("%-22s %s\n", f.Name, value)
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+func TestDBsDoNotCollide(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "select 0", "set k zero", "set k0 only0", "select 1", "set k one", "select 10", "set k ten")
+	for _, db := range []struct {
+		n    string
+		k    string
+		scan string
+	}{
+		{"0", "zero", "\"k\":\"zero\"\n\"k0\":\"only0\"\n"},
+		{"1", "one", "\"k\":\"one\"\n"},
+		{"10", "ten", "\"k\":\"ten\"\n"},
+	} {
+		run(t, c, "select "+db.n)
+		if val := mustGet(t, c, "k"); string(val) != db.k {
+			t.Errorf("db %s: got %q, want %q", db.n, val, db.k)
+		}
+		if out := output(t, c, "scan ''"); out != db.scan {
+			t.Errorf("db %s: scan got %q, want %q", db.n, out, db.scan)
+		}
+	}
+
+	// none of the keyspaces is a prefix of another one
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 20; j++ {
+			if i != j && bytes.HasPrefix(tikvclient.DBKeyspace(i), tikvclient.DBKeyspace(j)) {
+				t.Fatalf("the keyspace of db %d starts with the one of db %d", i, j)
+			}
+		}
+	}
+}
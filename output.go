@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// missing is printed in place of the value of a missing key
+const missing = "(nil)"
+
+// kvPair is the JSON representation of a key/value pair
+type kvPair = tikvclient.Pair
+
+// outputWriter renders the key/value pairs produced by get and scan
+type outputWriter interface {
+	// Write writes a pair, a nil val means the key is missing
+	Write(key, val []byte) error
+	// Flush emits anything buffered, it should be called once after the last Write
+	Flush() error
+}
+
+func newOutputWriter(w io.Writer, opts *Options) outputWriter {
+	var split *keySplitter
+	if opts.KeySplit != "" {
+		split = &keySplitter{sep: []byte(opts.KeySplit), n: opts.KeyColumns}
+	}
+	if opts.tmpl != nil {
+		return &templateWriter{w: w, tmpl: opts.tmpl}
+	}
+	switch opts.Output {
+	case "json":
+		if opts.JSONPretty {
+			return &jsonArrayWriter{w: w, pairs: []kvPair{}}
+		}
+		return &jsonLineWriter{enc: json.NewEncoder(w)}
+	case "ndjson-with-meta":
+		return &metaWriter{enc: json.NewEncoder(w), fields: opts.metaFields}
+	case "csv":
+		return &csvWriter{cellEncoder: cellEncoder{split: split, binary: opts.BinaryCells}, w: csv.NewWriter(w)}
+	case "tsv":
+		return &tsvWriter{cellEncoder: cellEncoder{split: split, binary: opts.BinaryCells}, w: w}
+	case "table":
+		return &tableWriter{w: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0), split: split}
+	case "hex", "base64", "raw":
+		return &lineWriter{w: w, format: opts.Output}
+	default:
+		if colorEnabled(w, opts) {
+			return &colorWriter{w: w}
+		}
+		return &textWriter{w: w, split: split}
+	}
+}
+
+// keySplitter splits composite keys like user:123:profile into columns
+type keySplitter struct {
+	sep []byte
+	n   int // number of columns, inferred from the first key if 0
+}
+
+// columns splits the key into exactly n parts, keys with fewer parts are
+// padded with empty columns and the extra parts are merged into the last one
+func (ks *keySplitter) columns(key []byte) [][]byte {
+	if ks.n <= 0 {
+		ks.n = bytes.Count(key, ks.sep) + 1
+	}
+	parts := bytes.SplitN(key, ks.sep, ks.n)
+	for len(parts) < ks.n {
+		parts = append(parts, []byte{})
+	}
+	return parts
+}
+
+// row returns the cells of a key/value pair
+func (ks *keySplitter) row(key, val []byte) [][]byte {
+	if ks == nil {
+		return [][]byte{key, val}
+	}
+	return append(ks.columns(key), val)
+}
+
+// header returns the column names of the rows
+func (ks *keySplitter) header() []string {
+	if ks == nil {
+		return []string{"KEY", "VALUE"}
+	}
+	var names []string
+	for i := 0; i < ks.n; i++ {
+		names = append(names, fmt.Sprintf("KEY%d", i+1))
+	}
+	return append(names, "VALUE")
+}
+
+// textWriter prints pairs as quoted strings, one pair per line
+type textWriter struct {
+	w     io.Writer
+	split *keySplitter
+}
+
+func (tw *textWriter) Write(key, val []byte) error {
+	if tw.split == nil {
+		if val == nil {
+			_, err := fmt.Fprintf(tw.w, "%q:%s\n", string(key), missing)
+			return err
+		}
+		_, err := fmt.Fprintf(tw.w, "%q:%q\n", string(key), string(val))
+		return err
+	}
+	_, err := fmt.Fprintln(tw.w, strings.Join(quoteCells(tw.split.row(key, val)), "\t"))
+	return err
+}
+
+func (tw *textWriter) Flush() error {
+	return nil
+}
+
+// formatKV formats a pair as a line of the hex, base64 or raw output without
+// the newline, the key and the value are separated by a tab and a missing key
+// has no value
+func formatKV(key, val []byte, format string) []byte {
+	encode := func(data []byte) []byte { return data }
+	switch format {
+	case "hex":
+		encode = func(data []byte) []byte { return []byte(hex.EncodeToString(data)) }
+	case "base64":
+		encode = func(data []byte) []byte { return []byte(base64.StdEncoding.EncodeToString(data)) }
+	}
+	line := append([]byte{}, encode(key)...)
+	if val == nil {
+		return line
+	}
+	return append(append(line, '\t'), encode(val)...)
+}
+
+// lineWriter prints every pair formatted by formatKV on its own line
+type lineWriter struct {
+	w      io.Writer
+	format string
+}
+
+func (lw *lineWriter) Write(key, val []byte) error {
+	_, err := lw.w.Write(append(formatKV(key, val, lw.format), '\n'))
+	return err
+}
+
+func (lw *lineWriter) Flush() error {
+	return nil
+}
+
+// keyWriter prints only the keys, or only the values, one per line
+type keyWriter struct {
+	w      io.Writer
+	format string
+	enc    *json.Encoder
+	values bool // print the values instead of the keys
+}
+
+// newKeyWriter creates the writer of scan --keys-only, or --values-only if
+// values, for the line oriented output formats
+func newKeyWriter(w io.Writer, opts *Options, values bool) (*keyWriter, error) {
+	flag := "--keys-only"
+	if values {
+		flag = "--values-only"
+	}
+	if opts.tmpl != nil {
+		return nil, fmt.Errorf("%s can not be used with templates", flag)
+	}
+	switch opts.Output {
+	case "text", "hex", "base64", "raw":
+		return &keyWriter{w: w, format: opts.Output, values: values}, nil
+	case "json":
+		return &keyWriter{w: w, format: opts.Output, enc: json.NewEncoder(w), values: values}, nil
+	}
+	return nil, fmt.Errorf("%s can only be used with --output text, json, hex, base64 or raw", flag)
+}
+
+func (kw *keyWriter) Write(key, val []byte) error {
+	data := key
+	if kw.values {
+		data = val
+	}
+	switch kw.format {
+	case "text":
+		_, err := fmt.Fprintf(kw.w, "%q\n", string(data))
+		return err
+	case "json":
+		if kw.values {
+			return kw.enc.Encode(struct {
+				Value []byte `json:"value"`
+			}{val})
+		}
+		return kw.enc.Encode(struct {
+			Key []byte `json:"key"`
+		}{key})
+	}
+	_, err := kw.w.Write(append(formatKV(data, nil, kw.format), '\n'))
+	return err
+}
+
+func (kw *keyWriter) Flush() error {
+	return nil
+}
+
+// indexWriter prefixes every line written by a line oriented writer with its 1-based index
+type indexWriter struct {
+	outputWriter
+	w io.Writer
+	n int64
+}
+
+func (iw *indexWriter) Write(key, val []byte) error {
+	iw.n++
+	if _, err := fmt.Fprintf(iw.w, "%d) ", iw.n); err != nil {
+		return err
+	}
+	return iw.outputWriter.Write(key, val)
+}
+
+// cellEncoder turns the pairs into the cells of the csv and tsv records, the
+// cells which are not text are encoded so the file stays valid
+type cellEncoder struct {
+	split  *keySplitter
+	binary string // hex, base64 or raw
+	header bool   // emit the column names before the first record
+}
+
+// cell returns the text of a cell, a nil cell is the value of a missing key
+// printed as (nil). Binary data, text starting with the marker of the
+// encoding and the text (nil) itself are written as the marker followed by
+// the encoded bytes, like hex:00ff, so a reader can tell them apart.
+func (ce *cellEncoder) cell(data []byte) string {
+	if data == nil {
+		return missing
+	}
+	marker := ce.binary + ":"
+	if ce.binary == "raw" || !isBinary(data) && !bytes.HasPrefix(data, []byte(marker)) && string(data) != missing {
+		return string(data)
+	}
+	if ce.binary == "base64" {
+		return marker + base64.StdEncoding.EncodeToString(data)
+	}
+	return marker + hex.EncodeToString(data)
+}
+
+// record returns the cells of a pair
+func (ce *cellEncoder) record(key, val []byte) []string {
+	var record []string
+	for _, cell := range ce.split.row(key, val) {
+		record = append(record, ce.cell(cell))
+	}
+	return record
+}
+
+// columns returns the header once, after the first record split the key or
+// on Flush if there was none, and nil afterwards
+func (ce *cellEncoder) columns() []string {
+	if !ce.header {
+		return nil
+	}
+	ce.header = false
+	if ce.split != nil && ce.split.n <= 0 {
+		ce.split.n = 1
+	}
+	return ce.split.header()
+}
+
+// isBinary reports whether the data is not UTF-8 text or has control
+// characters other than tabs and line breaks, which spreadsheets mangle
+func isBinary(data []byte) bool {
+	if !utf8.Valid(data) {
+		return true
+	}
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' || b == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// csvWriter emits the cells as RFC 4180 records, the cells with commas, quotes
+// or line breaks are quoted
+type csvWriter struct {
+	cellEncoder
+	w *csv.Writer
+}
+
+func (cw *csvWriter) Write(key, val []byte) error {
+	record := cw.record(key, val)
+	if header := cw.columns(); header != nil {
+		if err := cw.w.Write(header); err != nil {
+			return err
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Flush() error {
+	if header := cw.columns(); header != nil {
+		if err := cw.w.Write(header); err != nil {
+			return err
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// tsvEscaper escapes the separators of the tsv cells
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// tsvWriter emits the cells separated by tabs, one record per line, with the
+// backslashes, tabs and line breaks of the cells escaped as \\, \t, \n and \r
+type tsvWriter struct {
+	cellEncoder
+	w io.Writer
+}
+
+func (tw *tsvWriter) Write(key, val []byte) error {
+	record := tw.record(key, val)
+	if header := tw.columns(); header != nil {
+		if err := tw.writeRecord(header); err != nil {
+			return err
+		}
+	}
+	return tw.writeRecord(record)
+}
+
+func (tw *tsvWriter) writeRecord(record []string) error {
+	for i := range record {
+		record[i] = tsvEscaper.Replace(record[i])
+	}
+	_, err := fmt.Fprintln(tw.w, strings.Join(record, "\t"))
+	return err
+}
+
+func (tw *tsvWriter) Flush() error {
+	if header := tw.columns(); header != nil {
+		return tw.writeRecord(header)
+	}
+	return nil
+}
+
+// tableWriter aligns the cells in columns, the rows are buffered until Flush
+// because the column width depends on all of them
+type tableWriter struct {
+	w      *tabwriter.Writer
+	split  *keySplitter
+	header bool
+}
+
+func (tw *tableWriter) Write(key, val []byte) error {
+	row := quoteCells(tw.split.row(key, val))
+	if !tw.header {
+		tw.header = true
+		if _, err := fmt.Fprintln(tw.w, strings.Join(tw.split.header(), "\t")); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(tw.w, strings.Join(row, "\t"))
+	return err
+}
+
+func (tw *tableWriter) Flush() error {
+	return tw.w.Flush()
+}
+
+func quoteCells(cells [][]byte) []string {
+	quoted := make([]string, len(cells))
+	for i := range cells {
+		if cells[i] == nil {
+			quoted[i] = missing
+			continue
+		}
+		quoted[i] = fmt.Sprintf("%q", string(cells[i]))
+	}
+	return quoted
+}
+
+// jsonLineWriter emits one compact JSON object per line as soon as it is written
+type jsonLineWriter struct {
+	enc *json.Encoder
+}
+
+func (jw *jsonLineWriter) Write(key, val []byte) error {
+	return jw.enc.Encode(&kvPair{Key: key, Value: val})
+}
+
+func (jw *jsonLineWriter) Flush() error {
+	return nil
+}
+
+// jsonArrayWriter buffers all the pairs and emits a pretty printed JSON array on Flush
+type jsonArrayWriter struct {
+	w     io.Writer
+	pairs []kvPair
+}
+
+func (jw *jsonArrayWriter) Write(key, val []byte) error {
+	// the iterator may reuse its buffers, keep a copy
+	p := kvPair{Key: append([]byte{}, key...)}
+	if val != nil {
+		p.Value = append([]byte{}, val...)
+	}
+	jw.pairs = append(jw.pairs, p)
+	return nil
+}
+
+func (jw *jsonArrayWriter) Flush() error {
+	data, err := json.MarshalIndent(jw.pairs, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(jw.w, string(data))
+	return err
+}
+
+// templateFields are the fields available to the --template
+type templateFields struct {
+	Key, Value       string
+	KeyHex, ValueHex string
+	KeyLen, ValueLen int
+	Missing          bool // the key does not exist
+}
+
+// parseTemplate parses the template, a newline is appended if it does not end with one
+func parseTemplate(text string) (*template.Template, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return template.New("output").Parse(text)
+}
+
+// templateWriter renders every pair with a text/template
+type templateWriter struct {
+	w    io.Writer
+	tmpl *template.Template
+}
+
+func (tw *templateWriter) Write(key, val []byte) error {
+	return tw.tmpl.Execute(tw.w, &templateFields{
+		Key:      string(key),
+		Value:    string(val),
+		KeyHex:   hex.EncodeToString(key),
+		ValueHex: hex.EncodeToString(val),
+		KeyLen:   len(key),
+		ValueLen: len(val),
+		Missing:  val == nil,
+	})
+}
+
+func (tw *templateWriter) Flush() error {
+	return nil
+}
+
+// metaFields are the fields which can be selected by --meta-fields
+var metaFields = []string{"key", "value", "key_len", "value_len", "commit_ts"}
+
+// kvMeta is a key/value pair enriched with metadata, the fields not selected are omitted
+type kvMeta struct {
+	Key      []byte  `json:"key,omitempty"`
+	Value    []byte  `json:"value,omitempty"`
+	KeyLen   *int    `json:"key_len,omitempty"`
+	ValueLen *int    `json:"value_len,omitempty"`
+	CommitTS *uint64 `json:"commit_ts,omitempty"`
+}
+
+// metaWriter emits one kvMeta JSON object per line
+type metaWriter struct {
+	enc    *json.Encoder
+	fields map[string]bool
+	// commitTS looks up the commit timestamp of a key, it costs a request
+	// per key so it is only called if the commit_ts field is selected
+	commitTS func(key []byte) (uint64, error)
+}
+
+func (mw *metaWriter) Write(key, val []byte) error {
+	m := &kvMeta{}
+	if mw.fields["key"] {
+		m.Key = key
+	}
+	if mw.fields["value"] {
+		m.Value = val
+	}
+	if mw.fields["key_len"] {
+		n := len(key)
+		m.KeyLen = &n
+	}
+	if mw.fields["value_len"] {
+		n := len(val)
+		m.ValueLen = &n
+	}
+	if mw.fields["commit_ts"] && mw.commitTS != nil {
+		ts, err := mw.commitTS(key)
+		if err != nil {
+			return err
+		}
+		m.CommitTS = &ts
+	}
+	return mw.enc.Encode(m)
+}
+
+func (mw *metaWriter) Flush() error {
+	return nil
+}
+
+// groupWriter buffers the pairs and prints the keys grouped by value on Flush,
+// the groups are in the order of their first key. It fails once more than
+// limit keys are buffered.
+type groupWriter struct {
+	w      io.Writer
+	json   bool
+	limit  int
+	keys   int
+	index  map[string]int // value to the index of its group
+	values [][]byte
+	groups [][][]byte
+}
+
+func newGroupWriter(w io.Writer, opts *Options, limit int) *groupWriter {
+	return &groupWriter{w: w, json: opts.Output == "json", limit: limit, index: make(map[string]int)}
+}
+
+func (gw *groupWriter) Write(key, val []byte) error {
+	if gw.keys >= gw.limit {
+		return fmt.Errorf("group limit of %d keys exceeded, increase --group-limit or narrow the scan", gw.limit)
+	}
+	gw.keys++
+	key = append([]byte{}, key...)
+	i, ok := gw.index[string(val)]
+	if !ok {
+		i = len(gw.groups)
+		gw.index[string(val)] = i
+		gw.values = append(gw.values, append([]byte{}, val...))
+		gw.groups = append(gw.groups, nil)
+	}
+	gw.groups[i] = append(gw.groups[i], key)
+	return nil
+}
+
+func (gw *groupWriter) Flush() error {
+	if gw.json {
+		// the values are base64 encoded like the []byte fields of kvPair, the
+		// object is written by hand since a map would sort the groups
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for i, keys := range gw.groups {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			value, _ := json.Marshal(base64.StdEncoding.EncodeToString(gw.values[i]))
+			group, err := json.Marshal(keys)
+			if err != nil {
+				return err
+			}
+			buf.Write(value)
+			buf.WriteByte(':')
+			buf.Write(group)
+		}
+		buf.WriteString("}\n")
+		_, err := gw.w.Write(buf.Bytes())
+		return err
+	}
+	for i, keys := range gw.groups {
+		if _, err := fmt.Fprintf(gw.w, "%q -> [%s]\n", string(gw.values[i]), strings.Join(quoteCells(keys), ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeLine encodes data into a single line which decodeArg decodes back
+// with the same encoding. The escape encoding keeps the printable ASCII
+// characters and writes \\ for a backslash and \xNN for the other bytes.
+func encodeLine(data []byte, encoding string) string {
+	switch encoding {
+	case "hex":
+		return hex.EncodeToString(data)
+	case "base64":
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	var b strings.Builder
+	for _, c := range data {
+		switch {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	return b.String()
+}
+
+// lineFile is a file written line by line through a buffer
+type lineFile struct {
+	f        *os.File
+	w        *bufio.Writer
+	encoding string
+}
+
+func createLineFile(path, encoding string) (*lineFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &lineFile{f: f, w: bufio.NewWriter(f), encoding: encoding}, nil
+}
+
+func (lf *lineFile) writeLine(data []byte) error {
+	_, err := fmt.Fprintln(lf.w, encodeLine(data, lf.encoding))
+	return err
+}
+
+func (lf *lineFile) close() error {
+	err := lf.w.Flush()
+	if cerr := lf.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// splitFileWriter writes the keys and the values to separate files, line i
+// of the keys file is the key of line i of the values file. Either file may
+// be nil.
+type splitFileWriter struct {
+	keys, values *lineFile
+}
+
+func (sw *splitFileWriter) Write(key, val []byte) error {
+	if sw.keys != nil {
+		if err := sw.keys.writeLine(key); err != nil {
+			return err
+		}
+	}
+	if sw.values != nil {
+		return sw.values.writeLine(val)
+	}
+	return nil
+}
+
+// Flush flushes and closes both files
+func (sw *splitFileWriter) Flush() error {
+	var err error
+	for _, lf := range []*lineFile{sw.keys, sw.values} {
+		if lf == nil {
+			continue
+		}
+		if cerr := lf.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCellsMissingKey(t *testing.T) {
+	c := newTestCommand(t)
+	run(t, c, "set k1 1", "set k3 (nil)")
+	for _, o := range []struct {
+		output, want string
+	}{
+		{"csv", "k1,1\nk2,(nil)\nk3,hex:286e696c29\n"},
+		{"tsv", "k1\t1\nk2\t(nil)\nk3\thex:286e696c29\n"},
+	} {
+		c.opts.Output = o.output
+		out := capture(t, func() { runLine(c, "get k1 k2 k3") })
+		if out != o.want {
+			t.Errorf("%s: got %q, want %q", o.output, out, o.want)
+		}
+	}
+}
+
+func TestGroupByValueOrder(t *testing.T) {
+	c := newTestCommand(t)
+	// the first key holds the value sorting last
+	run(t, c, "set a z", "set b a", "set c z")
+	if out := output(t, c, "scan '' --group-by-value"); out != "\"z\" -> [\"a\", \"c\"]\n\"a\" -> [\"b\"]\n" {
+		t.Fatalf("got %q", out)
+	}
+	c.opts.Output = "json"
+	// base64 of z is eg== and of a is YQ==, a map would sort them the other way
+	if out := output(t, c, "scan '' --group-by-value"); out != "{\"eg==\":[\"YQ==\",\"Yw==\"],\"YQ==\":[\"Yg==\"]}\n" {
+		t.Fatalf("got %q", out)
+	}
+	if out := output(t, c, "scan x --group-by-value"); out != "{}\n" {
+		t.Fatalf("got %q for no group", out)
+	}
+}
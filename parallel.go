@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// orderedEmitter writes the pairs of concurrently scanned sub-ranges in the
+// order of the sub-ranges. The first unfinished sub-range is streamed and the
+// following ones are buffered until it finishes, at most limit pairs are buffered.
+type orderedEmitter struct {
+	mu       sync.Mutex
+	w        outputWriter
+	ordered  bool
+	next     int // the sub-range being streamed
+	done     []bool
+	buf      [][]kvPair
+	buffered int
+	limit    int
+	err      error
+}
+
+func newOrderedEmitter(w outputWriter, parts int, ordered bool, limit int) *orderedEmitter {
+	return &orderedEmitter{w: w, ordered: ordered, done: make([]bool, parts), buf: make([][]kvPair, parts), limit: limit}
+}
+
+// emit writes or buffers a pair of the sub-range, it returns false if the scan should stop
+func (e *orderedEmitter) emit(part int, key, val []byte) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return false
+	}
+	if !e.ordered || part == e.next {
+		if e.err = e.w.Write(key, val); e.err != nil {
+			return false
+		}
+		return true
+	}
+	if e.buffered >= e.limit {
+		e.err = fmt.Errorf("order buffer of %d pairs exceeded, increase --order-buffer or scan without --parallel", e.limit)
+		return false
+	}
+	e.buf[part] = append(e.buf[part], kvPair{Key: append([]byte{}, key...), Value: append([]byte{}, val...)})
+	e.buffered++
+	return true
+}
+
+// finish marks the sub-range as completely scanned and flushes the buffered
+// sub-ranges which are next in order
+func (e *orderedEmitter) finish(part int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.done[part] = true
+	for e.next < len(e.done) && e.done[e.next] {
+		e.next++
+		if e.next == len(e.done) {
+			break
+		}
+		for _, p := range e.buf[e.next] {
+			if e.err == nil {
+				e.err = e.w.Write(p.Key, p.Value)
+			}
+		}
+		e.buffered -= len(e.buf[e.next])
+		e.buf[e.next] = nil
+	}
+}
+
+// error returns the first failure of writing or buffering
+func (e *orderedEmitter) error() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
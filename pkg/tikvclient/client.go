@@ -0,0 +1,1245 @@
+// Package tikvclient is the client behind tikv-cli, it reads and writes the
+// keys of a TiKV cluster through the transactional or the RawKV API.
+//
+// A client is connected with Dial and tuned with its Set* methods, every
+// operation commits on its own unless a transaction is opened with Begin.
+// The errors are *ClientError, CodeOf tells them apart.
+package tikvclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/config"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// TikvClient is a connection to a TiKV cluster. The operations outside of an
+// explicit transaction are safe for concurrent use, the setters and the
+// explicit transaction are not.
+type TikvClient struct {
+	url   string
+	store Store
+	raw   *tikv.RawKVClient // set in raw mode instead of store
+	mode  string
+
+	// keyspace is prepended to every key, scans never leave it and
+	// the keys passed to callers are stripped of it
+	keyspace []byte
+
+	regionRetries int  // extra attempts of an operation failed with a region error
+	verbose       bool // report the region error backoffs of every operation
+	verify        bool // read the value back after Set and GetSet
+
+	// txn is the explicit transaction of the shell, nil if every operation
+	// commits its own
+	txn kv.Transaction
+
+	// txnSlots caps the transactions opened concurrently, nil for no limit
+	txnSlots chan struct{}
+
+	timeout time.Duration   // bound of every request, zero for none
+	ctx     context.Context // canceled to stop waiting for the operations
+
+	snapshotTS uint64 // timestamp of the reads, zero for the latest data
+
+	// conflictRetries is the number of extra attempts of a mutation failed
+	// with a write conflict
+	conflictRetries int
+
+	// metrics turns on the collection of the operation metrics
+	metrics bool
+}
+
+// Dial connects to the cluster in the mode txn, raw or auto which probes the
+// data to pick one of them
+func Dial(url, mode string) (_ *TikvClient, err error) {
+	defer classifyError(&err)
+
+	if url == "" {
+		return nil, errors.New("no cluster to connect to, the url is empty")
+	}
+	if !logVisible {
+		logrus.SetOutput(ioutil.Discard)
+	}
+	cli := &TikvClient{url: url, conflictRetries: DefaultConflictRetries}
+	if mode == ModeTxn || mode == ModeAuto {
+		store, err := tikv.Driver{}.Open(url)
+		if err != nil {
+			return nil, err
+		}
+		cli.store = store
+	}
+	if mode == ModeRaw || mode == ModeAuto {
+		addrs, err := pdAddrs(url)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := tikv.NewRawKVClient(addrs, config.GetGlobalConfig().Security)
+		if err != nil {
+			return nil, err
+		}
+		cli.raw = raw
+	}
+	// an unknown mode fails here without having connected
+	if mode, err = detectMode(mode, cli.hasTxnData, cli.hasRawData); err != nil {
+		return nil, err
+	}
+	// auto connected with both APIs, the unused one is dropped
+	if mode == ModeTxn && cli.raw != nil {
+		cli.raw.Close()
+		cli.raw = nil
+	}
+	if mode == ModeRaw {
+		cli.store = nil
+	}
+	cli.mode = mode
+	return cli, nil
+}
+
+// Mode returns the mode the client is connected in, txn or raw
+func (cli *TikvClient) Mode() string {
+	return cli.mode
+}
+
+// Close releases the connection, an open transaction is rolled back
+func (cli *TikvClient) Close() error {
+	if cli.txn != nil {
+		cli.txn.Rollback()
+		cli.txn = nil
+	}
+	if cli.raw != nil {
+		return cli.raw.Close()
+	}
+	return cli.store.Close()
+}
+
+// pdAddrs parses the PD addresses out of a url like tikv://pd1:2379,pd2:2379
+func pdAddrs(rawurl string) ([]string, error) {
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(u.Scheme) != "tikv" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no PD address")
+	}
+	return strings.Split(u.Host, ","), nil
+}
+
+// DBKeyspace returns the keyspace of the logical database n, the leading NUL
+// keeps it apart from printable keys and the trailing ':' keeps db1 and db10 disjoint
+func DBKeyspace(n int) []byte {
+	return []byte(fmt.Sprintf("\x00db%d:", n))
+}
+
+// SetKeyspace isolates all the following operations under the prefix
+func (cli *TikvClient) SetKeyspace(prefix []byte) {
+	cli.keyspace = prefix
+}
+
+// Keyspace returns the prefix set by SetKeyspace
+func (cli *TikvClient) Keyspace() []byte {
+	return cli.keyspace
+}
+
+// key maps a user key into the keyspace
+func (cli *TikvClient) key(key []byte) kv.Key {
+	if len(cli.keyspace) == 0 {
+		return kv.Key(key)
+	}
+	k := make([]byte, 0, len(cli.keyspace)+len(key))
+	return kv.Key(append(append(k, cli.keyspace...), key...))
+}
+
+// SetRegionErrorRetries sets the number of times Get, GetMany, Set and Delete
+// are retried after failing with a region error
+func (cli *TikvClient) SetRegionErrorRetries(n int) {
+	cli.regionRetries = n
+}
+
+// DefaultConflictRetries is the number of times a mutation is re-run after a
+// write conflict unless SetConflictRetries is called
+const DefaultConflictRetries = 10
+
+// conflict backoffs double from conflictBackoff up to maxConflictBackoff
+const (
+	conflictBackoff    = 10 * time.Millisecond
+	maxConflictBackoff = 500 * time.Millisecond
+)
+
+// SetConflictRetries sets the number of times Set, SetMany, GetSet, Delete,
+// BatchDelete and Incr are re-run after failing with a write conflict
+func (cli *TikvClient) SetConflictRetries(n int) {
+	cli.conflictRetries = n
+}
+
+// SetVerbose reports the region error backoffs of every operation to stderr
+func (cli *TikvClient) SetVerbose(verbose bool) {
+	cli.verbose = verbose
+}
+
+// SetVerify makes Set and GetSet read the value back after the commit and fail
+// if it differs, it doubles the round trips of a write
+func (cli *TikvClient) SetVerify(verify bool) {
+	cli.verify = verify
+}
+
+// verifyWrite reads the key back in a new transaction and fails if it does
+// not hold the written value
+func (cli *TikvClient) verifyWrite(key, val []byte) error {
+	got, err := cli.Get(key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, val) {
+		return fmt.Errorf("verify %s: read %d bytes back which differ from the %d bytes written", DisplayKey(key), len(got), len(val))
+	}
+	return nil
+}
+
+// SetMaxConcurrentTxns caps the number of transactions and snapshots the
+// concurrent operations keep open at the same time, 0 for no limit
+func (cli *TikvClient) SetMaxConcurrentTxns(n int) {
+	cli.txnSlots = nil
+	if n > 0 {
+		cli.txnSlots = make(chan struct{}, n)
+	}
+}
+
+// AcquireTxn waits for a free transaction slot of SetMaxConcurrentTxns and
+// returns its release, the callers running operations from several goroutines
+// hold one for every operation
+func (cli *TikvClient) AcquireTxn() func() {
+	if cli.txnSlots == nil {
+		return func() {}
+	}
+	cli.txnSlots <- struct{}{}
+	return func() { <-cli.txnSlots }
+}
+
+// regionErrorBackoffs returns the number of backoffs caused by region errors in this process
+func regionErrorBackoffs() int64 {
+	var total float64
+	for _, typ := range []string{"regionMiss", "updateLeader"} {
+		var m dto.Metric
+		if err := metrics.TiKVBackoffCounter.WithLabelValues(typ).Write(&m); err == nil {
+			total += m.GetCounter().GetValue()
+		}
+	}
+	return int64(total)
+}
+
+// withRegionRetry runs the operation and retries it at most regionRetries
+// times if the client gave up on a region error
+func (cli *TikvClient) withRegionRetry(op string, f func() error) error {
+	begin := time.Now()
+	err := cli.retryRegion(op, f)
+	cli.observe(op, begin, err)
+	return err
+}
+
+// retryRegion is withRegionRetry without recording the operation
+func (cli *TikvClient) retryRegion(op string, f func() error) error {
+	before := regionErrorBackoffs()
+	err := cli.wait(f)
+	for i := 0; i < cli.regionRetries && tikv.ErrRegionUnavailable.Equal(err); i++ {
+		err = cli.wait(f)
+	}
+	cli.reportRegionErrors(op, before)
+	cli.explainGC(&err)
+	return classify(err)
+}
+
+// withConflictRetry runs the mutation like withRegionRetry and re-runs it
+// at most conflictRetries times after a write conflict, waiting a backoff
+// before each attempt. The whole read-modify-write is run again since the
+// conflicting transaction may have changed what it read. An explicit
+// transaction is committed later, its conflicts are reported by the commit.
+func (cli *TikvClient) withConflictRetry(op string, f func() error) (err error) {
+	defer func(begin time.Time) { cli.observe(op, begin, err) }(time.Now())
+
+	err = cli.retryRegion(op, f)
+	backoff := conflictBackoff
+	for i := 0; i < cli.conflictRetries && cli.txn == nil && CodeOf(err) == ErrConflict; i++ {
+		if cli.verbose {
+			fmt.Fprintf(os.Stderr, "%s: write conflict, retry %d of %d in %v\n", op, i+1, cli.conflictRetries, backoff)
+		}
+		select {
+		case <-cli.context().Done():
+			return classify(errCanceled)
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxConflictBackoff {
+			backoff = maxConflictBackoff
+		}
+		err = cli.retryRegion(op, f)
+	}
+	return err
+}
+
+// reportRegionErrors prints the region error backoffs since before if verbose
+func (cli *TikvClient) reportRegionErrors(op string, before int64) {
+	if cli.verbose {
+		fmt.Fprintf(os.Stderr, "%s: %d region error retries\n", op, regionErrorBackoffs()-before)
+	}
+}
+
+// Get returns the value of the key, an ErrNotFound error if it is missing
+func (cli *TikvClient) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := cli.withRegionRetry("get", func() error {
+		if cli.snapshotTS != 0 {
+			snap, err := cli.snapshot()
+			if err != nil {
+				return err
+			}
+			val, err = snap.Get(cli.key(key))
+			return err
+		}
+		if cli.raw != nil {
+			var err error
+			if val, err = cli.raw.Get(cli.key(key)); err == nil && val == nil {
+				err = kv.ErrNotExist
+			}
+			return err
+		}
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		// reads need no commit, but the transaction has to be released
+		defer cli.rollback(txn)
+
+		val, err = txn.Get(cli.key(key))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	cli.countRead(key, val)
+	return val, nil
+}
+
+// Exists reports whether the key exists, only a missing key is false without
+// an error
+func (cli *TikvClient) Exists(key []byte) (bool, error) {
+	_, err := cli.Get(key)
+	if kv.IsErrNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetMany reads the keys from one transaction so the values are consistent with
+// each other. The values are in the order of the keys and nil for missing keys.
+func (cli *TikvClient) GetMany(keys [][]byte) ([][]byte, error) {
+	found, err := cli.BatchGet(keys)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		vals = append(vals, found[string(key)])
+	}
+	return vals, nil
+}
+
+// BatchGet reads the keys with one batched request per region from one
+// transaction, the missing keys are absent from the result
+func (cli *TikvClient) BatchGet(keys [][]byte) (map[string][]byte, error) {
+	found := make(map[string][]byte, len(keys))
+	err := cli.withRegionRetry("get", func() error {
+		if cli.raw != nil && cli.snapshotTS == 0 {
+			vals, err := cli.rawGetMany(keys)
+			if err != nil {
+				return err
+			}
+			for i, val := range vals {
+				if val != nil {
+					found[string(keys[i])] = val
+				}
+			}
+			return nil
+		}
+		var snap kv.Snapshot
+		if cli.snapshotTS != 0 {
+			var err error
+			if snap, err = cli.snapshot(); err != nil {
+				return err
+			}
+		} else {
+			txn, err := cli.begin()
+			if err != nil {
+				return err
+			}
+			defer cli.rollback(txn)
+
+			if cli.txn != nil {
+				// the snapshot does not see the writes of the explicit transaction
+				for _, key := range keys {
+					val, err := txn.Get(cli.key(key))
+					if err != nil && !kv.IsErrNotFound(err) {
+						return err
+					}
+					if val != nil {
+						found[string(key)] = val
+					}
+				}
+				return nil
+			}
+			snap = txn.GetSnapshot()
+		}
+		ks := make([]kv.Key, 0, len(keys))
+		for _, key := range keys {
+			ks = append(ks, cli.key(key))
+		}
+		vals, err := snap.BatchGet(ks)
+		if err != nil {
+			return err
+		}
+		for k, val := range vals {
+			found[k[len(cli.keyspace):]] = val
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range found {
+		cli.countRead([]byte(key), val)
+	}
+	return found, nil
+}
+
+// Set writes the value of the key
+func (cli *TikvClient) Set(key []byte, val []byte) error {
+	err := cli.withConflictRetry("set", func() error {
+		if cli.raw != nil {
+			return cli.raw.Put(cli.key(key), val)
+		}
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		err = txn.Set(cli.key(key), val)
+		if err != nil {
+			cli.rollback(txn)
+			return err
+		}
+
+		return cli.commit(txn)
+	})
+	if err == nil {
+		cli.countWritten(key, val)
+	}
+	// the writes of an explicit transaction are only visible to itself
+	if err == nil && cli.verify && cli.txn == nil {
+		err = cli.verifyWrite(key, val)
+	}
+	return err
+}
+
+// SetMany writes all the pairs in order in one transaction, so they land
+// atomically and the last value of a duplicated key wins
+func (cli *TikvClient) SetMany(pairs []Pair) error {
+	if err := cli.txnOnly("writing several keys atomically"); err != nil {
+		return err
+	}
+	err := cli.withConflictRetry("set", func() error {
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		for _, p := range pairs {
+			if err := txn.Set(cli.key(p.Key), p.Value); err != nil {
+				cli.rollback(txn)
+				return err
+			}
+		}
+		return cli.commit(txn)
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range pairs {
+		cli.countWritten(p.Key, p.Value)
+	}
+	return nil
+}
+
+// Scan iterates the keys from begin in ascending order and returns the number
+// of keys passed to each, it stops once each returns false. With deleteIf the
+// keys accepted by each and then by deleteIf are deleted in the scanning
+// transaction, which is committed at the end so either all of them or none
+// are deleted.
+func (cli *TikvClient) Scan(begin []byte, limit int64, deleteIf func(key []byte) bool, each func(key, val []byte) bool) (int64, error) {
+	return cli.ScanRange(begin, nil, limit, deleteIf, each)
+}
+
+// ScanRange works like Scan over [begin, end), an empty end means the end of
+// the keyspace. The iteration stops at the first key reaching end, which is
+// never passed to each. TiKV of the vendored client takes no end key, so the
+// batch holding it is still transferred.
+func (cli *TikvClient) ScanRange(begin, end []byte, limit int64, deleteIf func(key []byte) bool, each func(key, val []byte) bool) (_ int64, err error) {
+	defer func(begin time.Time) { cli.observe("scan", begin, err) }(time.Now())
+	defer classifyError(&err)
+	defer cli.explainGC(&err)
+
+	// the results of a scan are consumed as they arrive, so it is not retried
+	defer cli.reportRegionErrors("scan", regionErrorBackoffs())
+
+	if cli.snapshotTS != 0 && deleteIf != nil {
+		return 0, fmt.Errorf("the scan of a snapshot is read-only, it can not delete")
+	}
+	each = cli.countingEach(each)
+	if cli.raw != nil && cli.snapshotTS == 0 {
+		return cli.rawScan(begin, end, limit, deleteIf, each)
+	}
+	// the scan reads from the snapshot of SetSnapshot or from a transaction
+	var r kv.Retriever
+	var txn kv.Transaction
+	if cli.snapshotTS != 0 {
+		snap, err := cli.snapshot()
+		if err != nil {
+			return 0, err
+		}
+		r = snap
+	} else {
+		if txn, err = cli.begin(); err != nil {
+			return 0, err
+		}
+		r = txn
+		// a read-only scan is only rolled back, rolling back after the commit
+		// of a scan with delete is a no-op. An abandoned request may still be
+		// using the transaction, which is then left to the garbage collection.
+		defer func() {
+			if !isAbandoned(err) {
+				cli.rollback(txn)
+			}
+		}()
+	}
+
+	var iter kv.Iterator
+	if err := cli.wait(func() (err error) {
+		iter, err = r.Seek(cli.key(begin))
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if !isAbandoned(err) {
+			iter.Close()
+		}
+	}()
+	// count is the number of keys successfully passed to each, it is
+	// returned even if the iteration fails halfway
+	var count int64
+	for iter.Valid() && limit != 0 {
+		if !bytes.HasPrefix(iter.Key(), cli.keyspace) {
+			break
+		}
+		key := []byte(iter.Key()[len(cli.keyspace):])
+		if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		if !each(key, iter.Value()) {
+			break
+		}
+		if deleteIf != nil && deleteIf(key) {
+			if err := txn.Delete(iter.Key()); err != nil {
+				return count, err
+			}
+		}
+		count++
+		limit--
+		if err := cli.wait(iter.Next); err != nil {
+			return count, err
+		}
+	}
+
+	if deleteIf == nil {
+		return count, nil
+	}
+	if err := cli.wait(func() error { return cli.commit(txn) }); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Count returns the number of keys in [begin, end), an empty end means the end
+// of the keyspace. It stops counting at limit unless limit is negative.
+func (cli *TikvClient) Count(begin, end []byte, limit int64) (int64, error) {
+	// the vendored scanner has no keys only mode, so the values are
+	// transferred but never copied
+	return cli.ScanRange(begin, end, limit, nil, func(key, val []byte) bool {
+		return true
+	})
+}
+
+// ReverseScan iterates the keys less than begin in descending order, an empty
+// begin starts from the end of the keyspace. It returns the number of keys
+// passed to each.
+func (cli *TikvClient) ReverseScan(begin []byte, limit int64, each func(key, val []byte) bool) (_ int64, err error) {
+	defer func(begin time.Time) { cli.observe("scan", begin, err) }(time.Now())
+	defer classifyError(&err)
+	defer cli.explainGC(&err)
+
+	if err := cli.txnOnly("reverse scan"); err != nil {
+		return 0, err
+	}
+	if err := cli.autoCommitOnly("reverse scan"); err != nil {
+		return 0, err
+	}
+	defer cli.reportRegionErrors("scan", regionErrorBackoffs())
+	each = cli.countingEach(each)
+
+	ver, err := cli.readVersion()
+	if err != nil {
+		return 0, err
+	}
+	snap, err := cli.store.GetSnapshot(ver)
+	if err != nil {
+		return 0, err
+	}
+
+	var upper kv.Key
+	if len(begin) > 0 {
+		upper = cli.key(begin)
+	} else if len(cli.keyspace) > 0 {
+		upper = kv.Key(cli.keyspace).PrefixNext()
+	}
+	lower := kv.Key(cli.keyspace)
+
+	// the snapshot of TiKV can only seek forward, so the range is read in
+	// chunks backward from the upper bound, each chunk is scanned forward and
+	// its last pairs are emitted in reverse
+	var count int64
+	for limit != 0 {
+		if err := cli.interrupted(); err != nil {
+			return count, err
+		}
+		start, err := cli.reverseChunkStart(upper, lower)
+		if err != nil {
+			return count, err
+		}
+		pairs, err := lastPairs(snap, start, upper, limit)
+		if err != nil {
+			return count, err
+		}
+		for i := len(pairs) - 1; i >= 0; i-- {
+			if !each(pairs[i].Key[len(cli.keyspace):], pairs[i].Value) {
+				return count, nil
+			}
+			count++
+			limit--
+		}
+		if bytes.Compare(start, lower) <= 0 {
+			break
+		}
+		upper = start
+	}
+	return count, nil
+}
+
+// ParallelScan splits [begin, end) into at most n sub-ranges and scans them
+// concurrently from one snapshot, an empty end means the end of the keyspace.
+// each is called with the index of the sub-range and may be called
+// concurrently for different sub-ranges, returning false stops all of them.
+// done is called once a sub-range has been completely scanned.
+func (cli *TikvClient) ParallelScan(begin, end []byte, n int, each func(part int, key, val []byte) bool, done func(part int)) (_ int64, err error) {
+	defer func(begin time.Time) { cli.observe("scan", begin, err) }(time.Now())
+	defer classifyError(&err)
+	defer cli.explainGC(&err)
+
+	if err := cli.txnOnly("parallel scan"); err != nil {
+		return 0, err
+	}
+	if err := cli.autoCommitOnly("parallel scan"); err != nil {
+		return 0, err
+	}
+	defer cli.reportRegionErrors("scan", regionErrorBackoffs())
+
+	ver, err := cli.readVersion()
+	if err != nil {
+		return 0, err
+	}
+	begin = cli.key(begin)
+	if len(end) > 0 || len(cli.keyspace) > 0 {
+		end = cli.key(end)
+		if len(end) == len(cli.keyspace) {
+			end = kv.Key(cli.keyspace).PrefixNext()
+		}
+	}
+
+	var count int64
+	var stopped int32
+	var wg sync.WaitGroup
+	ranges := splitRange(begin, end, n)
+	errs := make([]error, len(ranges))
+	for i := range ranges {
+		wg.Add(1)
+		go func(part int, begin, end []byte) {
+			defer wg.Done()
+			defer cli.AcquireTxn()()
+			if atomic.LoadInt32(&stopped) != 0 {
+				return
+			}
+			snap, err := cli.store.GetSnapshot(ver)
+			if err != nil {
+				errs[part] = err
+				return
+			}
+			iter, err := snap.Seek(kv.Key(begin))
+			if err != nil {
+				errs[part] = err
+				return
+			}
+			defer iter.Close()
+			for iter.Valid() && atomic.LoadInt32(&stopped) == 0 {
+				if len(end) > 0 && bytes.Compare(iter.Key(), end) >= 0 {
+					break
+				}
+				key := []byte(iter.Key()[len(cli.keyspace):])
+				cli.countRead(key, iter.Value())
+				if !each(part, key, iter.Value()) {
+					atomic.StoreInt32(&stopped, 1)
+					return
+				}
+				atomic.AddInt64(&count, 1)
+				err := cli.interrupted()
+				if err == nil {
+					err = iter.Next()
+				}
+				if err != nil {
+					errs[part] = err
+					atomic.StoreInt32(&stopped, 1)
+					return
+				}
+			}
+			if atomic.LoadInt32(&stopped) == 0 {
+				done(part)
+			}
+		}(i, ranges[i][0], ranges[i][1])
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// Delete removes the key, a missing key is not an error
+func (cli *TikvClient) Delete(key []byte) error {
+	return cli.withConflictRetry("delete", func() error {
+		if cli.raw != nil {
+			return cli.raw.Delete(cli.key(key))
+		}
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		if err := txn.Delete(cli.key(key)); err != nil {
+			cli.rollback(txn)
+			return err
+		}
+		return cli.commit(txn)
+	})
+}
+
+// DeleteReport deletes the key and reports whether it existed, the check and
+// the delete are in one transaction. A missing key is not an error.
+func (cli *TikvClient) DeleteReport(key []byte) (bool, error) {
+	deleted, err := cli.BatchDelete([][]byte{key})
+	return len(deleted) > 0, err
+}
+
+// BatchDelete deletes the keys in one transaction, it returns the keys which
+// existed and were deleted along with their values in the order of the keys
+func (cli *TikvClient) BatchDelete(keys [][]byte) ([]Pair, error) {
+	var deleted []Pair
+	err := cli.withConflictRetry("delete", func() error {
+		if cli.raw != nil {
+			var err error
+			deleted, err = cli.rawBatchDelete(keys)
+			return err
+		}
+		deleted = nil
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			val, err := txn.Get(cli.key(key))
+			if err != nil {
+				if kv.IsErrNotFound(err) {
+					continue
+				}
+				cli.rollback(txn)
+				return err
+			}
+			if err := txn.Delete(cli.key(key)); err != nil {
+				cli.rollback(txn)
+				return err
+			}
+			deleted = append(deleted, Pair{Key: key, Value: val})
+		}
+		if len(deleted) == 0 {
+			return cli.rollback(txn)
+		}
+		return cli.commit(txn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// GetSet writes the value and returns the previous one in one transaction,
+// nil if the key did not exist
+func (cli *TikvClient) GetSet(key, val []byte) ([]byte, error) {
+	var old []byte
+	err := cli.withConflictRetry("set", func() error {
+		if cli.raw != nil {
+			var err error
+			if old, err = cli.raw.Get(cli.key(key)); err != nil {
+				return err
+			}
+			return cli.raw.Put(cli.key(key), val)
+		}
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		if old, err = txn.Get(cli.key(key)); err != nil && !kv.IsErrNotFound(err) {
+			cli.rollback(txn)
+			return err
+		}
+		if err := txn.Set(cli.key(key), val); err != nil {
+			cli.rollback(txn)
+			return err
+		}
+		return cli.commit(txn)
+	})
+	if err == nil {
+		if old != nil {
+			cli.countRead(key, old)
+		}
+		cli.countWritten(key, val)
+	}
+	// the writes of an explicit transaction are only visible to itself
+	if err == nil && cli.verify && cli.txn == nil {
+		err = cli.verifyWrite(key, val)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// RestorePairs writes the values of the pairs in one transaction, the keys of
+// pairs with a nil value are deleted
+func (cli *TikvClient) RestorePairs(pairs []Pair) error {
+	err := cli.withRegionRetry("restore", func() error {
+		if cli.raw != nil {
+			for _, p := range pairs {
+				var err error
+				if p.Value == nil {
+					err = cli.raw.Delete(cli.key(p.Key))
+				} else {
+					err = cli.raw.Put(cli.key(p.Key), p.Value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		for _, p := range pairs {
+			if p.Value == nil {
+				err = txn.Delete(cli.key(p.Key))
+			} else {
+				err = txn.Set(cli.key(p.Key), p.Value)
+			}
+			if err != nil {
+				cli.rollback(txn)
+				return err
+			}
+		}
+		return cli.commit(txn)
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range pairs {
+		cli.countWritten(p.Key, p.Value)
+	}
+	return nil
+}
+
+// Incr adds delta to the base-10 integer value of the key in one transaction
+// and returns the new value, a missing or empty value is 0. The transaction is
+// retried on write conflicts, so concurrent increments are not lost.
+func (cli *TikvClient) Incr(key []byte, delta int64) (int64, error) {
+	if err := cli.txnOnly("incr"); err != nil {
+		return 0, err
+	}
+	var n int64
+	incr := func() error {
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		old, err := txn.Get(cli.key(key))
+		if err != nil && !kv.IsErrNotFound(err) {
+			cli.rollback(txn)
+			return err
+		}
+		var cur int64
+		if s := strings.TrimSpace(string(old)); s != "" {
+			if cur, err = strconv.ParseInt(s, 10, 64); err != nil {
+				cli.rollback(txn)
+				return fmt.Errorf("the value of %s is not an integer: %q", DisplayKey(key), old)
+			}
+		}
+		if delta > 0 && cur > math.MaxInt64-delta || delta < 0 && cur < math.MinInt64-delta {
+			cli.rollback(txn)
+			return fmt.Errorf("incrementing %d by %d overflows", cur, delta)
+		}
+		if err := txn.Set(cli.key(key), []byte(strconv.FormatInt(cur+delta, 10))); err != nil {
+			cli.rollback(txn)
+			return err
+		}
+		if err := cli.commit(txn); err != nil {
+			return err
+		}
+		n = cur + delta
+		return nil
+	}
+	if err := cli.withConflictRetry("incr", incr); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// CollisionError is returned by a rename without overwrite if target keys exist
+type CollisionError struct {
+	Keys [][]byte
+}
+
+func (e *CollisionError) Error() string {
+	const max = 10
+	var keys []string
+	for i := 0; i < len(e.Keys) && i < max; i++ {
+		keys = append(keys, DisplayKey(e.Keys[i]))
+	}
+	if len(e.Keys) > max {
+		keys = append(keys, "...")
+	}
+	return fmt.Sprintf("%d target keys already exist: %s", len(e.Keys), strings.Join(keys, ", "))
+}
+
+// Rename moves the value of src to dst in one transaction, an existing dst
+// is a collision unless overwrite is set
+func (cli *TikvClient) Rename(src, dst []byte, overwrite bool) error {
+	return cli.copyKey("rename", src, dst, overwrite, true)
+}
+
+// Copy writes the value of src to dst in one transaction, an existing dst is
+// a collision unless overwrite is set. A missing src fails with not found.
+func (cli *TikvClient) Copy(src, dst []byte, overwrite bool) error {
+	return cli.copyKey("copy", src, dst, overwrite, false)
+}
+
+// copyKey copies src to dst and deletes src if move
+func (cli *TikvClient) copyKey(op string, src, dst []byte, overwrite, move bool) error {
+	if err := cli.txnOnly(op); err != nil {
+		return classify(err)
+	}
+	if bytes.Equal(src, dst) {
+		return classify(fmt.Errorf("the source and target are the same key"))
+	}
+	var val []byte
+	err := cli.withConflictRetry(op, func() error {
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		if val, err = txn.Get(cli.key(src)); err != nil {
+			cli.rollback(txn)
+			return err
+		}
+		if !overwrite {
+			if _, err := txn.Get(cli.key(dst)); err == nil {
+				cli.rollback(txn)
+				return &CollisionError{Keys: [][]byte{dst}}
+			} else if !kv.IsErrNotFound(err) {
+				cli.rollback(txn)
+				return err
+			}
+		}
+		if err := txn.Set(cli.key(dst), val); err != nil {
+			cli.rollback(txn)
+			return err
+		}
+		if move {
+			if err := txn.Delete(cli.key(src)); err != nil {
+				cli.rollback(txn)
+				return err
+			}
+		}
+		return cli.commit(txn)
+	})
+	if err == nil {
+		cli.countRead(src, val)
+		cli.countWritten(dst, val)
+	}
+	return err
+}
+
+// RenamePrefix moves all the keys under the src prefix to the dst prefix in one
+// transaction. All the targets are checked before any write, if any of them
+// exists a CollisionError is returned unless overwrite is set.
+func (cli *TikvClient) RenamePrefix(src, dst []byte, overwrite bool) (int64, error) {
+	if err := cli.txnOnly("rename"); err != nil {
+		return 0, classify(err)
+	}
+	if bytes.HasPrefix(src, dst) || bytes.HasPrefix(dst, src) {
+		return 0, classify(fmt.Errorf("the source and target prefixes overlap"))
+	}
+	var moved []Pair
+	err := cli.withConflictRetry("rename", func() error {
+		txn, err := cli.begin()
+		if err != nil {
+			return err
+		}
+		moved, err = cli.renamePrefix(txn, src, dst, overwrite)
+		if err != nil || len(moved) == 0 {
+			cli.rollback(txn)
+			return err
+		}
+		return cli.commit(txn)
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range moved {
+		cli.countRead(p.Key, p.Value)
+		cli.countWritten(append(append([]byte{}, dst...), p.Key[len(src):]...), p.Value)
+	}
+	return int64(len(moved)), nil
+}
+
+// renamePrefix writes the moves of RenamePrefix in the transaction and
+// returns the source pairs, the transaction is left to the caller
+func (cli *TikvClient) renamePrefix(txn kv.Transaction, src, dst []byte, overwrite bool) ([]Pair, error) {
+	// the first pass collects the sources
+	start := cli.key(src)
+	iter, err := txn.Seek(start)
+	if err != nil {
+		return nil, err
+	}
+	var keys, vals [][]byte
+	for iter.Valid() && bytes.HasPrefix(iter.Key(), start) {
+		keys = append(keys, append([]byte{}, iter.Key()...))
+		vals = append(vals, append([]byte{}, iter.Value()...))
+		if err := iter.Next(); err != nil {
+			iter.Close()
+			return nil, err
+		}
+	}
+	iter.Close()
+
+	// the second pass checks the targets
+	targets := make([]kv.Key, len(keys))
+	collisions := &CollisionError{}
+	for i, key := range keys {
+		targets[i] = cli.key(append(append([]byte{}, dst...), key[len(start):]...))
+		if overwrite {
+			continue
+		}
+		if _, err := txn.Get(targets[i]); err == nil {
+			collisions.Keys = append(collisions.Keys, targets[i][len(cli.keyspace):])
+		} else if !kv.IsErrNotFound(err) {
+			return nil, err
+		}
+	}
+	if len(collisions.Keys) > 0 {
+		return nil, collisions
+	}
+
+	moved := make([]Pair, len(keys))
+	for i := range keys {
+		if err := txn.Set(targets[i], vals[i]); err != nil {
+			return nil, err
+		}
+		if err := txn.Delete(keys[i]); err != nil {
+			return nil, err
+		}
+		moved[i] = Pair{Key: keys[i][len(cli.keyspace):], Value: vals[i]}
+	}
+	return moved, nil
+}
+
+// DeletePrefix deletes all the keys with the prefix in transactions of at most
+// batch keys, it returns the number of deleted keys. progress, unless nil, is
+// called with the number of the keys deleted so far after every transaction.
+func (cli *TikvClient) DeletePrefix(prefix []byte, batch int, progress func(deleted int64)) (_ int64, err error) {
+	defer func(begin time.Time) { cli.observe("delete", begin, err) }(time.Now())
+	defer classifyError(&err)
+	defer cli.explainGC(&err)
+
+	if err := cli.txnOnly("deleting a prefix"); err != nil {
+		return 0, err
+	}
+	if err := cli.autoCommitOnly("deleting a prefix"); err != nil {
+		return 0, err
+	}
+	defer cli.reportRegionErrors("delete", regionErrorBackoffs())
+
+	start := cli.key(prefix)
+	var total int64
+	for {
+		if err := cli.interrupted(); err != nil {
+			return total, err
+		}
+		n, err := cli.deleteBatch(start, batch)
+		if err != nil {
+			return total, err
+		}
+		if n == 0 {
+			return total, nil
+		}
+		total += int64(n)
+		if progress != nil {
+			progress(total)
+		}
+		if n < batch {
+			return total, nil
+		}
+	}
+}
+
+// deleteBatch deletes at most batch keys with the prefix in a transaction of
+// its own and returns their number
+func (cli *TikvClient) deleteBatch(start kv.Key, batch int) (_ int, err error) {
+	defer cli.AcquireTxn()()
+
+	txn, err := cli.begin()
+	if err != nil {
+		return 0, err
+	}
+	// rolling back after the commit is a no-op, an abandoned request may still
+	// be using the transaction like in ScanRange
+	defer func() {
+		if !isAbandoned(err) {
+			cli.rollback(txn)
+		}
+	}()
+	// the keys deleted by the previous batches are invisible, so seek from
+	// the start of the prefix every time
+	var iter kv.Iterator
+	if err := cli.wait(func() (err error) {
+		iter, err = txn.Seek(start)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if !isAbandoned(err) {
+			iter.Close()
+		}
+	}()
+	n := 0
+	for iter.Valid() && bytes.HasPrefix(iter.Key(), start) && n < batch {
+		if err := txn.Delete(iter.Key()); err != nil {
+			return 0, err
+		}
+		n++
+		if err := cli.wait(iter.Next); err != nil {
+			return 0, err
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, cli.wait(func() error { return cli.commit(txn) })
+}
+
+// CommitTS returns the commit timestamp of the latest version of the key
+func (cli *TikvClient) CommitTS(key []byte) (_ uint64, err error) {
+	defer classifyError(&err)
+
+	if err := cli.txnOnly("commit_ts"); err != nil {
+		return 0, err
+	}
+	store, ok := cli.store.(tikv.Storage)
+	if !ok {
+		return 0, fmt.Errorf("commit ts is not supported by the store")
+	}
+	k := cli.key(key)
+	bo := tikv.NewBackoffer(cli.context(), 20000)
+	for {
+		loc, err := store.GetRegionCache().LocateKey(bo, k)
+		if err != nil {
+			return 0, err
+		}
+		req := &tikvrpc.Request{
+			Type:         tikvrpc.CmdMvccGetByKey,
+			MvccGetByKey: &kvrpcpb.MvccGetByKeyRequest{Key: k},
+		}
+		resp, err := store.SendReq(bo, req, loc.Region, time.Minute)
+		if err != nil {
+			return 0, err
+		}
+		regionErr, err := resp.GetRegionError()
+		if err != nil {
+			return 0, err
+		}
+		if regionErr != nil {
+			if err := bo.Backoff(tikv.BoRegionMiss, errors.New(regionErr.String())); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		mvcc := resp.MvccGetByKey
+		if mvcc == nil {
+			return 0, tikv.ErrBodyMissing
+		}
+		if mvcc.Error != "" {
+			return 0, errors.New(mvcc.Error)
+		}
+		var ts uint64
+		for _, w := range mvcc.GetInfo().GetWrites() {
+			if w.Type == kvrpcpb.Op_Put && w.CommitTs > ts {
+				ts = w.CommitTs
+			}
+		}
+		return ts, nil
+	}
+}
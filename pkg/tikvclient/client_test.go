@@ -0,0 +1,457 @@
+package tikvclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/kv"
+)
+
+// newTestClient returns a client on an empty mem store holding the pairs
+func newTestClient(t *testing.T, pairs ...string) *TikvClient {
+	cli := NewClient(NewMemStore())
+	setPairs(t, cli, pairs...)
+	return cli
+}
+
+// setPairs writes the key and value pairs of the list
+func setPairs(t *testing.T, cli *TikvClient, pairs ...string) {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if err := cli.Set([]byte(pairs[i]), []byte(pairs[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// scanKeys returns the keys of ScanRange joined by commas
+func scanKeys(t *testing.T, cli *TikvClient, begin, end string, limit int64) string {
+	var keys []string
+	_, err := cli.ScanRange([]byte(begin), []byte(end), limit, nil, func(key, val []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprint(keys)
+}
+
+func TestScanLimit(t *testing.T) {
+	cli := newTestClient(t, "a", "1", "b", "2", "c", "3")
+	for _, c := range []struct {
+		limit int64
+		keys  string
+	}{
+		{0, "[]"},
+		{2, "[a b]"},
+		{3, "[a b c]"},
+		{10, "[a b c]"},
+		{-1, "[a b c]"},
+	} {
+		if keys := scanKeys(t, cli, "", "", c.limit); keys != c.keys {
+			t.Errorf("limit %d: got %s, want %s", c.limit, keys, c.keys)
+		}
+	}
+	n, err := cli.Scan(nil, 2, nil, func(key, val []byte) bool { return true })
+	if err != nil || n != 2 {
+		t.Fatalf("got %d, %v, want 2 keys", n, err)
+	}
+}
+
+func TestScanPrefixBounds(t *testing.T) {
+	cli := newTestClient(t, "a", "1", "b", "2", "b1", "3", "b\xff", "4", "c", "5")
+	if keys := scanKeys(t, cli, "b", "c", -1); keys != "[b b1 b\xff]" {
+		t.Fatalf("got %s", keys)
+	}
+	if keys := scanKeys(t, cli, "b1", "b\xff", -1); keys != "[b1]" {
+		t.Fatalf("end is exclusive, got %s", keys)
+	}
+
+	// the keyspace bounds the scans and is stripped from the keys
+	cli.SetKeyspace([]byte("b"))
+	if keys := scanKeys(t, cli, "", "", -1); keys != "[ 1 \xff]" {
+		t.Fatalf("got %q", keys)
+	}
+}
+
+func TestScanDelete(t *testing.T) {
+	cli := newTestClient(t, "k1", "1", "k2", "2", "k3", "3", "l1", "4")
+	n, err := cli.Scan([]byte("k"), -1, func(key []byte) bool {
+		return !bytes.Equal(key, []byte("k2"))
+	}, func(key, val []byte) bool {
+		return bytes.HasPrefix(key, []byte("k"))
+	})
+	if err != nil || n != 3 {
+		t.Fatalf("got %d, %v, want 3 keys", n, err)
+	}
+	if keys := scanKeys(t, cli, "", "", -1); keys != "[k2 l1]" {
+		t.Fatalf("got %s after the delete", keys)
+	}
+}
+
+func TestScanDeleteRange(t *testing.T) {
+	store := &conflictStore{Store: NewMemStore(), key: []byte("k3")}
+	cli := NewClient(store)
+	setPairs(t, cli, "k1", "1", "k2", "2", "k3", "3", "k4", "4")
+	all := func(key []byte) bool { return true }
+
+	// the deletes are committed at once, a failed commit deletes nothing
+	store.conflicts = 2
+	cli.SetConflictRetries(1)
+	if _, err := cli.ScanRange([]byte("k2"), []byte("k4"), -1, all, func(key, val []byte) bool { return true }); CodeOf(err) != ErrConflict {
+		t.Fatalf("got %v, want a conflict", err)
+	}
+	store.conflicts = 0
+	if keys := scanKeys(t, cli, "", "", -1); keys != "[k1 k2 k3 k4]" {
+		t.Fatalf("got %s after the failed delete", keys)
+	}
+
+	n, err := cli.ScanRange([]byte("k2"), []byte("k4"), -1, all, func(key, val []byte) bool { return true })
+	if err != nil || n != 2 {
+		t.Fatalf("got %d, %v, want 2 keys", n, err)
+	}
+	if keys := scanKeys(t, cli, "", "", -1); keys != "[k1 k4]" {
+		t.Fatalf("got %s after deleting [k2, k4)", keys)
+	}
+}
+
+// conflictStore commits a write of key after each of the first conflicts
+// transactions began, so their commits conflict
+type conflictStore struct {
+	Store
+	key       []byte
+	conflicts int
+}
+
+func (s *conflictStore) Begin() (kv.Transaction, error) {
+	txn, err := s.Store.Begin()
+	if err != nil || s.conflicts == 0 {
+		return txn, err
+	}
+	s.conflicts--
+	other, err := s.Store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := other.Set(s.key, []byte("other")); err != nil {
+		return nil, err
+	}
+	return txn, other.Commit(context.Background())
+}
+
+func TestSetConflictRetry(t *testing.T) {
+	store := &conflictStore{Store: NewMemStore(), key: []byte("k"), conflicts: 2}
+	cli := NewClient(store)
+	if err := cli.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("the conflicts were not retried: %v", err)
+	}
+	if val, err := cli.Get([]byte("k")); err != nil || string(val) != "v" {
+		t.Fatalf("got %q, %v", val, err)
+	}
+
+	store.conflicts = 2
+	cli.SetConflictRetries(1)
+	if err := cli.Set([]byte("k"), []byte("v")); CodeOf(err) != ErrConflict {
+		t.Fatalf("got %v, want a conflict", err)
+	}
+}
+
+func TestRenamePrefixCollision(t *testing.T) {
+	cli := newTestClient(t, "old/a", "1", "old/b", "2", "new/b", "3")
+	n, err := cli.RenamePrefix([]byte("old/"), []byte("new/"), false)
+	if !IsCollision(err) || n != 0 {
+		t.Fatalf("got %d, %v, want a collision", n, err)
+	}
+	// nothing was written
+	if keys := scanKeys(t, cli, "", "", -1); keys != "[new/b old/a old/b]" {
+		t.Fatalf("got %s after the collision", keys)
+	}
+
+	n, err = cli.RenamePrefix([]byte("old/"), []byte("new/"), true)
+	if err != nil || n != 2 {
+		t.Fatalf("got %d, %v, want 2 keys renamed", n, err)
+	}
+	if val, err := cli.Get([]byte("new/b")); err != nil || string(val) != "2" {
+		t.Fatalf("got %q, %v", val, err)
+	}
+	if keys := scanKeys(t, cli, "", "", -1); keys != "[new/a new/b]" {
+		t.Fatalf("got %s after the rename", keys)
+	}
+}
+
+func TestRenameConflictRetry(t *testing.T) {
+	store := &conflictStore{Store: NewMemStore(), key: []byte("dst")}
+	cli := NewClient(store)
+	setPairs(t, cli, "src", "1")
+	store.conflicts = 1
+	if err := cli.Rename([]byte("src"), []byte("dst"), true); err != nil {
+		t.Fatalf("the conflict was not retried: %v", err)
+	}
+	if val, err := cli.Get([]byte("dst")); err != nil || string(val) != "1" {
+		t.Fatalf("got %q, %v", val, err)
+	}
+	if err := cli.Rename([]byte("dst"), []byte("src"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Copy([]byte("src"), []byte("src2"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Copy([]byte("src"), []byte("src2"), false); !IsCollision(err) {
+		t.Fatalf("got %v, want a collision", err)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	cli := newTestClient(t, "a", "0", "p1", "1", "p2", "2", "p3", "3", "p4", "4", "p5", "5", "q", "6")
+	cli.SetMaxConcurrentTxns(1)
+	var progress []int64
+	n, err := cli.DeletePrefix([]byte("p"), 2, func(deleted int64) {
+		progress = append(progress, deleted)
+	})
+	if err != nil || n != 5 {
+		t.Fatalf("got %d, %v, want 5 keys", n, err)
+	}
+	if fmt.Sprint(progress) != "[2 4 5]" {
+		t.Fatalf("got the progress %v", progress)
+	}
+	if keys := scanKeys(t, cli, "", "", -1); keys != "[a q]" {
+		t.Fatalf("got %s after the delete", keys)
+	}
+}
+
+// countingStore counts the transactions begun
+type countingStore struct {
+	Store
+	begins int
+}
+
+func (s *countingStore) Begin() (kv.Transaction, error) {
+	s.begins++
+	return s.Store.Begin()
+}
+
+func TestGetManySingleBegin(t *testing.T) {
+	store := &countingStore{Store: NewMemStore()}
+	cli := NewClient(store)
+	setPairs(t, cli, "k1", "1", "k3", "3")
+	store.begins = 0
+	vals, err := cli.GetMany([][]byte{[]byte("k1"), []byte("k2"), []byte("k3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%q", vals) != `["1" "" "3"]` || vals[1] != nil {
+		t.Fatalf("got %q", vals)
+	}
+	if store.begins != 1 {
+		t.Fatalf("%d transactions were begun for 3 keys, want 1", store.begins)
+	}
+}
+
+func TestBatchDeleteMixed(t *testing.T) {
+	store := &countingStore{Store: NewMemStore()}
+	cli := NewClient(store)
+	setPairs(t, cli, "k1", "1", "k3", "3")
+	store.begins = 0
+	deleted, err := cli.BatchDelete([][]byte{[]byte("k0"), []byte("k1"), []byte("k2"), []byte("k3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%q", deleted) != fmt.Sprintf("%q", []Pair{{Key: []byte("k1"), Value: []byte("1")}, {Key: []byte("k3"), Value: []byte("3")}}) {
+		t.Fatalf("got %q, want k1 and k3 with their values", deleted)
+	}
+	if store.begins != 1 {
+		t.Fatalf("%d transactions were begun, want 1", store.begins)
+	}
+	if keys := scanKeys(t, cli, "", "", -1); keys != "[]" {
+		t.Fatalf("got %s after the delete", keys)
+	}
+
+	// deleting only absent keys deletes nothing and is not an error
+	if deleted, err := cli.BatchDelete([][]byte{[]byte("k1")}); err != nil || len(deleted) != 0 {
+		t.Fatalf("got %q, %v", deleted, err)
+	}
+}
+
+// corruptingStore silently writes the values with their last byte flipped
+type corruptingStore struct {
+	Store
+}
+
+func (s corruptingStore) Begin() (kv.Transaction, error) {
+	txn, err := s.Store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return corruptingTxn{txn}, nil
+}
+
+type corruptingTxn struct {
+	kv.Transaction
+}
+
+func (txn corruptingTxn) Set(k kv.Key, v []byte) error {
+	corrupted := append([]byte(nil), v...)
+	corrupted[len(corrupted)-1] ^= 1
+	return txn.Transaction.Set(k, corrupted)
+}
+
+func TestSetVerify(t *testing.T) {
+	cli := NewClient(corruptingStore{NewMemStore()})
+	if err := cli.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("the write was verified without --verify: %v", err)
+	}
+	cli.SetVerify(true)
+	err := cli.Set([]byte("k"), []byte("v"))
+	if err == nil || !strings.Contains(err.Error(), "differ") {
+		t.Fatalf("got %v, want the mismatch reported", err)
+	}
+
+	cli = newTestClient(t)
+	cli.SetVerify(true)
+	if err := cli.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("a faithful write failed the verification: %v", err)
+	}
+}
+
+// openStore tracks the snapshots whose iterators are open at the same time
+type openStore struct {
+	Store
+	mu        sync.Mutex
+	open, max int
+}
+
+func (s *openStore) GetSnapshot(ver kv.Version) (kv.Snapshot, error) {
+	snap, err := s.Store.GetSnapshot(ver)
+	if err != nil {
+		return nil, err
+	}
+	return openSnapshot{snap, s}, nil
+}
+
+func (s *openStore) add(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open += n
+	if s.open > s.max {
+		s.max = s.open
+	}
+}
+
+type openSnapshot struct {
+	kv.Snapshot
+	store *openStore
+}
+
+func (snap openSnapshot) Seek(k kv.Key) (kv.Iterator, error) {
+	iter, err := snap.Snapshot.Seek(k)
+	if err != nil {
+		return nil, err
+	}
+	snap.store.add(1)
+	return openIter{iter, snap.store}, nil
+}
+
+type openIter struct {
+	kv.Iterator
+	store *openStore
+}
+
+func (iter openIter) Close() {
+	iter.Iterator.Close()
+	iter.store.add(-1)
+}
+
+func TestMaxConcurrentTxns(t *testing.T) {
+	store := &openStore{Store: NewMemStore()}
+	cli := NewClient(store)
+	for b := 0; b < 256; b++ {
+		setPairs(t, cli, string([]byte{byte(b), 'k'}), "v")
+	}
+	cli.SetMaxConcurrentTxns(3)
+
+	// several parallel scans at once share the cap
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n, err := cli.ParallelScan(nil, nil, 8, func(part int, key, val []byte) bool {
+				time.Sleep(100 * time.Microsecond)
+				return true
+			}, func(part int) {})
+			if err == nil && n != 256 {
+				err = fmt.Errorf("scanned %d keys, want 256", n)
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.max > 3 {
+		t.Fatalf("%d transactions were open at the same time, the cap is 3", store.max)
+	}
+	if store.open != 0 {
+		t.Fatalf("%d transactions are still open", store.open)
+	}
+}
+
+// releaseStore counts the transactions begun and not yet committed or rolled
+// back
+type releaseStore struct {
+	Store
+	open int
+}
+
+func (s *releaseStore) Begin() (kv.Transaction, error) {
+	txn, err := s.Store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	s.open++
+	return releaseTxn{txn, s}, nil
+}
+
+type releaseTxn struct {
+	kv.Transaction
+	store *releaseStore
+}
+
+func (txn releaseTxn) Commit(ctx context.Context) error {
+	txn.store.open--
+	return txn.Transaction.Commit(ctx)
+}
+
+func (txn releaseTxn) Rollback() error {
+	txn.store.open--
+	return txn.Transaction.Rollback()
+}
+
+func TestReadsReleaseTxns(t *testing.T) {
+	store := &releaseStore{Store: NewMemStore()}
+	cli := NewClient(store)
+	setPairs(t, cli, "a", "1", "b", "2")
+	for i := 0; i < 5000; i++ {
+		if val, err := cli.Get([]byte("a")); err != nil || string(val) != "1" {
+			t.Fatalf("get #%d: got %q, %v", i, val, err)
+		}
+		if _, err := cli.Get([]byte("missing")); CodeOf(err) != ErrNotFound {
+			t.Fatalf("get #%d: got %v, want not found", i, err)
+		}
+		if keys := scanKeys(t, cli, "", "", -1); keys != "[a b]" {
+			t.Fatalf("scan #%d: got %s", i, keys)
+		}
+	}
+	if store.open != 0 {
+		t.Fatalf("%d transactions were never released", store.open)
+	}
+}
@@ -0,0 +1,32 @@
+package tikvclient
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// maxConnectBackoff caps the exponential backoff between the connection attempts
+const maxConnectBackoff = 30 * time.Second
+
+// DialRetry is Dial retried up to retries more times, waiting backoff before
+// the first retry and twice as long before every next one. A url which can not
+// be parsed fails at once, anything else like an unreachable PD is retried.
+func DialRetry(url, mode string, retries int, backoff time.Duration) (*TikvClient, error) {
+	if url != "" {
+		if _, err := pdAddrs(url); err != nil {
+			return nil, fmt.Errorf("invalid url %q: %v", url, err)
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		cli, err := Dial(url, mode)
+		if err == nil || url == "" || attempt > retries {
+			return cli, err
+		}
+		fmt.Fprintf(os.Stderr, "connect attempt %d of %d failed: %v, retrying in %v\n", attempt, retries+1, err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
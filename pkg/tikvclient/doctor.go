@@ -0,0 +1,189 @@
+package tikvclient
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/config"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+)
+
+// the status of a diagnostic check
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// Diagnosis is the outcome of a diagnostic check, hint suggests how to fix a
+// warning or failure
+type Diagnosis struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// maxClockSkew is the skew of the local clock against the TSO which is
+// reported as a warning
+const maxClockSkew = 500 * time.Millisecond
+
+// maxSafePointAge is the age of the GC safe point which is reported as a warning
+const maxSafePointAge = 24 * time.Hour
+
+// Diagnose runs the diagnostic checks in order, the checks needing a connection
+// are skipped if it can not be established
+func Diagnose(rawurl string, keyspace []byte) []Diagnosis {
+	var ds []Diagnosis
+	addrs, d := checkURL(rawurl)
+	ds = append(ds, d)
+	if d.Status == checkFail {
+		return ds
+	}
+	d = checkPD(addrs)
+	ds = append(ds, d)
+	if d.Status == checkFail {
+		return ds
+	}
+	ds = append(ds, checkTLS())
+
+	cli, err := Dial(rawurl, ModeTxn)
+	if err != nil {
+		return append(ds, Diagnosis{Check: "connect", Status: checkFail, Detail: err.Error(),
+			Hint: "PD is reachable but the client failed to open the store, check the cluster id and that TiKV is up"})
+	}
+	cli.SetKeyspace(keyspace)
+	ds = append(ds, Diagnosis{Check: "connect", Status: checkPass, Detail: cli.store.UUID()})
+	ds = append(ds, cli.checkClockSkew())
+	ds = append(ds, cli.checkSafePoint())
+	ds = append(ds, cli.checkRoundTrip())
+	return ds
+}
+
+// checkURL parses the PD addresses out of the url
+func checkURL(rawurl string) ([]string, Diagnosis) {
+	addrs, err := pdAddrs(rawurl)
+	if err != nil {
+		return nil, Diagnosis{Check: "url", Status: checkFail, Detail: err.Error(), Hint: "use --url tikv://pd1:2379,pd2:2379"}
+	}
+	return addrs, Diagnosis{Check: "url", Status: checkPass, Detail: fmt.Sprintf("%d PD address(es)", len(addrs))}
+}
+
+// checkPD opens a TCP connection to every PD address
+func checkPD(addrs []string) Diagnosis {
+	var down []string
+	for _, addr := range addrs {
+		conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+		if err != nil {
+			down = append(down, addr)
+			continue
+		}
+		conn.Close()
+	}
+	d := Diagnosis{Check: "pd", Status: checkPass, Detail: fmt.Sprintf("%d of %d reachable", len(addrs)-len(down), len(addrs))}
+	if len(down) == len(addrs) {
+		d.Status = checkFail
+		d.Hint = "check the addresses, the PD processes and the firewall"
+	} else if len(down) > 0 {
+		d.Status = checkWarn
+		d.Hint = "unreachable: " + strings.Join(down, ",")
+	}
+	return d
+}
+
+// checkTLS loads the cluster certificates if TLS is configured
+func checkTLS() Diagnosis {
+	security := config.GetGlobalConfig().Security
+	if security.ClusterSSLCA == "" {
+		return Diagnosis{Check: "tls", Status: checkPass, Detail: "not configured, connecting in plaintext"}
+	}
+	if _, err := security.ToTLSConfig(); err != nil {
+		return Diagnosis{Check: "tls", Status: checkFail, Detail: err.Error(),
+			Hint: "check the paths and the PEM encoding of the CA, certificate and key"}
+	}
+	return Diagnosis{Check: "tls", Status: checkPass, Detail: "certificates loaded"}
+}
+
+// checkClockSkew compares the local clock with the physical time of a new
+// timestamp, the middle of the request is taken as the local time
+func (cli *TikvClient) checkClockSkew() Diagnosis {
+	d := Diagnosis{Check: "clock"}
+	before := time.Now()
+	ver, err := cli.store.CurrentVersion()
+	if err != nil {
+		d.Status, d.Detail, d.Hint = checkFail, err.Error(), "PD failed to allocate a timestamp"
+		return d
+	}
+	rtt := time.Since(before)
+	local := before.Add(rtt / 2)
+	tso := time.Unix(0, oracle.ExtractPhysical(ver.Ver)*int64(time.Millisecond))
+	skew := local.Sub(tso)
+	d.Status, d.Detail = checkPass, fmt.Sprintf("skew %v, round trip %v", skew, rtt)
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		d.Status, d.Hint = checkWarn, "synchronize the clocks of this host and PD with NTP"
+	}
+	return d
+}
+
+// checkSafePoint reports the age of the GC safe point saved by TiDB
+func (cli *TikvClient) checkSafePoint() Diagnosis {
+	d := Diagnosis{Check: "gc"}
+	store, ok := cli.store.(tikv.Storage)
+	if !ok {
+		d.Status, d.Detail = checkWarn, "the store does not expose the safe point"
+		return d
+	}
+	value, err := store.GetSafePointKV().Get(tikv.GcSavedSafePoint)
+	if err != nil {
+		d.Status, d.Detail = checkFail, err.Error()
+		return d
+	}
+	if value == "" {
+		d.Status, d.Detail = checkWarn, "no safe point saved"
+		d.Hint = "GC is driven by TiDB, old versions are never collected without it"
+		return d
+	}
+	sp, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		d.Status, d.Detail = checkFail, fmt.Sprintf("invalid safe point %q", value)
+		return d
+	}
+	age := time.Since(time.Unix(0, oracle.ExtractPhysical(sp)*int64(time.Millisecond)))
+	d.Status, d.Detail = checkPass, fmt.Sprintf("safe point %d, %v ago", sp, age.Round(time.Second))
+	if age > maxSafePointAge {
+		d.Status, d.Hint = checkWarn, "GC may be stalled, check the GC worker of TiDB"
+	}
+	return d
+}
+
+// checkRoundTrip writes, reads back and deletes a temporary key
+func (cli *TikvClient) checkRoundTrip() Diagnosis {
+	d := Diagnosis{Check: "read-write", Status: checkFail}
+	key := []byte(fmt.Sprintf("\x00tikv-cli-doctor:%d", time.Now().UnixNano()))
+	val := []byte("ok")
+	begin := time.Now()
+	if err := cli.Set(key, val); err != nil {
+		d.Detail, d.Hint = err.Error(), "the write failed, check the TiKV stores and their disk space"
+		return d
+	}
+	got, err := cli.Get(key)
+	if err != nil {
+		d.Detail = err.Error()
+		return d
+	}
+	if !bytes.Equal(got, val) {
+		d.Detail = fmt.Sprintf("read %q back, expect %q", got, val)
+		return d
+	}
+	if err := cli.Delete(key); err != nil {
+		d.Detail = fmt.Sprintf("the key %s is left behind: %v", DisplayKey(key), err)
+		return d
+	}
+	d.Status, d.Detail = checkPass, fmt.Sprintf("took %v", time.Since(begin))
+	return d
+}
@@ -0,0 +1,236 @@
+package tikvclient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// dumpMagic starts a file written by Dump, it is followed by the version
+const dumpMagic = "TIKVDUMP"
+
+const dumpVersion = 1
+
+// the records of a dump, every pair is a dumpPair record and the file ends
+// with a dumpEnd record holding the number of the pairs and the CRC-32 of all
+// the records before it, so a truncated or corrupted file is detected
+const (
+	dumpPair = 'p'
+	dumpEnd  = 'e'
+)
+
+// restoreBatch is the number of pairs Restore writes in one transaction
+const restoreBatch = 256
+
+// maxDumpField is the max size of a key or value read from a dump, it only
+// guards against allocating garbage lengths of a corrupted file
+const maxDumpField = 1 << 30
+
+// Dump writes the pairs of [begin, end) to w in the binary dump format, an
+// empty end means the end of the keyspace. It returns the number of the
+// pairs written.
+func (cli *TikvClient) Dump(w io.Writer, begin, end []byte) (int64, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(dumpMagic); err != nil {
+		return 0, err
+	}
+	if err := bw.WriteByte(dumpVersion); err != nil {
+		return 0, err
+	}
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(bw, crc)
+	var werr error
+	count, err := cli.ScanRange(begin, end, -1, nil, func(key, val []byte) bool {
+		werr = writeDumpRecord(out, dumpPair, key, val)
+		return werr == nil
+	})
+	if err == nil {
+		err = werr
+	}
+	if err != nil {
+		return count, err
+	}
+	// the trailer is not covered by its own checksum
+	var trailer [1 + binary.MaxVarintLen64 + 4]byte
+	trailer[0] = dumpEnd
+	n := 1 + binary.PutUvarint(trailer[1:], uint64(count))
+	binary.BigEndian.PutUint32(trailer[n:], crc.Sum32())
+	if _, err := bw.Write(trailer[:n+4]); err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+// Restore writes the pairs of a dump in transactions of restoreBatch pairs,
+// it returns the number of the pairs written. The file is checked as it is
+// read, so the batches before a damaged part are already written, see
+// CheckDump to check it first.
+func (cli *TikvClient) Restore(r io.Reader) (int64, error) {
+	if err := cli.autoCommitOnly("restore"); err != nil {
+		return 0, err
+	}
+	var restored int64
+	var batch []Pair
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := cli.RestorePairs(batch); err != nil {
+			return err
+		}
+		restored += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+	_, err := readDump(r, func(p Pair) error {
+		batch = append(batch, p)
+		if len(batch) < restoreBatch {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return restored, err
+	}
+	return restored, flush()
+}
+
+// CheckDump reads the whole dump and verifies its framing and checksum, it
+// returns the number of the pairs
+func CheckDump(r io.Reader) (int64, error) {
+	return readDump(r, nil)
+}
+
+func writeDumpRecord(w io.Writer, tag byte, key, val []byte) error {
+	buf := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(key)+len(val))
+	buf = append(buf, tag)
+	buf = appendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = appendUvarint(buf, uint64(len(val)))
+	buf = append(buf, val...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+// readDump calls each with every pair of the dump, each may be nil to only
+// check the file. The pairs are passed before the trailer is verified.
+func readDump(r io.Reader, each func(p Pair) error) (int64, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(dumpMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, fmt.Errorf("not a dump file: %v", err)
+	}
+	if string(header[:len(dumpMagic)]) != dumpMagic {
+		return 0, fmt.Errorf("not a dump file")
+	}
+	if header[len(dumpMagic)] != dumpVersion {
+		return 0, fmt.Errorf("unsupported dump version %d", header[len(dumpMagic)])
+	}
+	// the pair records are read through the checksum, the trailer is not
+	in := &crcReader{r: br, crc: crc32.NewIEEE()}
+	var count int64
+	for {
+		tag, err := br.ReadByte()
+		if err == io.EOF {
+			return count, fmt.Errorf("the dump is truncated after %d pairs", count)
+		} else if err != nil {
+			return count, err
+		}
+		switch tag {
+		case dumpPair:
+			in.crc.Write([]byte{tag})
+			key, err := readDumpField(in)
+			if err != nil {
+				return count, fmt.Errorf("the dump is truncated after %d pairs: %v", count, err)
+			}
+			val, err := readDumpField(in)
+			if err != nil {
+				return count, fmt.Errorf("the dump is truncated after %d pairs: %v", count, err)
+			}
+			if each != nil {
+				if err := each(Pair{Key: key, Value: val}); err != nil {
+					return count, err
+				}
+			}
+			count++
+		case dumpEnd:
+			return count, readDumpTrailer(br, in.crc.Sum32(), count)
+		default:
+			return count, fmt.Errorf("the dump is corrupted after %d pairs: unknown record %q", count, tag)
+		}
+	}
+}
+
+// readDumpTrailer checks the rest of the end record against the pairs read,
+// nothing may follow it
+func readDumpTrailer(br *bufio.Reader, sum uint32, count int64) error {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fmt.Errorf("the dump is truncated in its trailer: %v", unexpectedEOF(err))
+	}
+	var want [4]byte
+	if _, err := io.ReadFull(br, want[:]); err != nil {
+		return fmt.Errorf("the dump is truncated in its trailer: %v", unexpectedEOF(err))
+	}
+	if n != uint64(count) {
+		return fmt.Errorf("the dump is corrupted: it has %d pairs, the trailer says %d", count, n)
+	}
+	if binary.BigEndian.Uint32(want[:]) != sum {
+		return fmt.Errorf("the dump is corrupted: checksum mismatch")
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return fmt.Errorf("the dump is corrupted: data after the trailer")
+	}
+	return nil
+}
+
+// crcReader updates the checksum with the bytes read
+type crcReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (cr *crcReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc.Write(p[:n])
+	return n, err
+}
+
+func readDumpField(in *crcReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(in)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if n > maxDumpField {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	// the values are never nil, a nil value would delete the key
+	field := make([]byte, n)
+	if _, err := io.ReadFull(in, field); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return field, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package tikvclient
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorCode classifies the errors of TikvClient so scripts can rely on them
+type ErrorCode int
+
+const (
+	ErrUnknown ErrorCode = iota
+	ErrNotFound
+	ErrConflict
+	ErrTimeout
+	ErrConnection
+)
+
+var errorCodeNames = [...]string{"unknown", "not_found", "conflict", "timeout", "connection"}
+
+func (code ErrorCode) String() string {
+	return errorCodeNames[code]
+}
+
+// ExitCode is the exit status of a command failed with the code, 1 for
+// unknown errors and 2 to 5 for the others in the order of their declaration
+func (code ErrorCode) ExitCode() int {
+	return int(code) + 1
+}
+
+// ClientError is an error of TikvClient along with its code
+type ClientError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *ClientError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error, so kv.IsErrNotFound and the like still
+// recognize the wrapped errors
+func (e *ClientError) Cause() error {
+	return errors.Cause(e.Err)
+}
+
+// classify wraps the error into a ClientError, nil stays nil
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*ClientError); ok {
+		return err
+	}
+	return &ClientError{Code: classifyCode(err), Err: err}
+}
+
+// classifyError classifies the error in place, it is meant to be deferred
+// with a named result
+func classifyError(err *error) {
+	*err = classify(*err)
+}
+
+// classifyCode maps the errors of the TiKV client to a code. Most TiKV
+// errors carry the "try again later" mark, so the timeouts and connection
+// failures are told apart before the conflicts.
+func classifyCode(err error) ErrorCode {
+	cause := errors.Cause(err)
+	grpcCode := codes.Unknown
+	if s, ok := status.FromError(cause); ok {
+		grpcCode = s.Code()
+	}
+	if e, ok := cause.(*abandonedError); ok {
+		if e.timeout == 0 {
+			return ErrUnknown
+		}
+		return ErrTimeout
+	}
+	switch {
+	case kv.IsErrNotFound(cause):
+		return ErrNotFound
+	case tikv.ErrTiKVServerTimeout.Equal(cause), tikv.ErrPDServerTimeout.Equal(cause),
+		tikv.ErrResolveLockTimeout.Equal(cause), tikv.ErrTiKVServerBusy.Equal(cause),
+		cause == context.DeadlineExceeded, grpcCode == codes.DeadlineExceeded:
+		return ErrTimeout
+	case tikv.ErrRegionUnavailable.Equal(cause), grpcCode == codes.Unavailable:
+		return ErrConnection
+	}
+	if _, ok := cause.(net.Error); ok {
+		return ErrConnection
+	}
+	if kv.IsRetryableError(err) || strings.Contains(err.Error(), "write conflict") {
+		return ErrConflict
+	}
+	return ErrUnknown
+}
+
+// IsCollision reports whether the error is a CollisionError, which the
+// operations return wrapped in a ClientError
+func IsCollision(err error) bool {
+	_, ok := errors.Cause(err).(*CollisionError)
+	return ok
+}
+
+// CodeOf returns the code of any error, ErrUnknown if it is not a ClientError
+func CodeOf(err error) ErrorCode {
+	if e, ok := err.(*ClientError); ok {
+		return e.Code
+	}
+	return ErrUnknown
+}
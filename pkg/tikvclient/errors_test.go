@@ -0,0 +1,49 @@
+package tikvclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/tidb/kv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyCode(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		code ErrorCode
+		exit int
+	}{
+		{kv.ErrNotExist, ErrNotFound, 2},
+		{errors.Trace(kv.ErrNotExist), ErrNotFound, 2},
+		{fmt.Errorf("write conflict, txnStartTS=1"), ErrConflict, 3},
+		{kv.ErrRetryable, ErrConflict, 3},
+		{context.DeadlineExceeded, ErrTimeout, 4},
+		{&abandonedError{timeout: time.Second}, ErrTimeout, 4},
+		{status.Error(codes.DeadlineExceeded, "deadline"), ErrTimeout, 4},
+		{status.Error(codes.Unavailable, "no connection"), ErrConnection, 5},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}, ErrConnection, 5},
+		{&abandonedError{}, ErrUnknown, 1},
+		{fmt.Errorf("something else"), ErrUnknown, 1},
+	} {
+		err := classify(c.err)
+		if code := CodeOf(err); code != c.code || code.ExitCode() != c.exit {
+			t.Errorf("%v: got %v exiting %d, want %v exiting %d", c.err, code, code.ExitCode(), c.code, c.exit)
+		}
+		if err.Error() != c.err.Error() {
+			t.Errorf("the message %q was changed to %q", c.err, err)
+		}
+	}
+	if classify(nil) != nil || CodeOf(fmt.Errorf("plain")) != ErrUnknown {
+		t.Fatal("nil or unclassified errors got a code")
+	}
+	// the wrapped errors are still recognized
+	if !kv.IsErrNotFound(errors.Cause(classify(kv.ErrNotExist))) {
+		t.Fatal("the not found error is not recognized once classified")
+	}
+}
@@ -0,0 +1,47 @@
+package tikvclient
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/store/tikv/oracle"
+)
+
+// InfoField is a named property of the cluster returned by Info
+type InfoField struct {
+	Name, Value string
+}
+
+// Info returns what identifies the cluster the client is connected to, in a
+// stable order, starting with the connect mode. The TSO is only available with the transactional API and the
+// cluster id only with the RawKV one.
+func (cli *TikvClient) Info() (_ []InfoField, err error) {
+	defer classifyError(&err)
+
+	addrs, err := pdAddrs(cli.url)
+	if err != nil {
+		return nil, err
+	}
+	fields := []InfoField{
+		{"mode", cli.mode},
+		{"pd", strings.Join(addrs, ",")},
+	}
+	if cli.raw != nil {
+		return append(fields, InfoField{"cluster_id", strconv.FormatUint(cli.raw.ClusterID(), 10)}), nil
+	}
+	fields = append(fields, InfoField{"uuid", cli.store.UUID()})
+	var ts uint64
+	if err := cli.wait(func() error {
+		ver, err := cli.store.CurrentVersion()
+		ts = ver.Ver
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	physical := time.Unix(0, oracle.ExtractPhysical(ts)*int64(time.Millisecond))
+	return append(fields,
+		InfoField{"tso", strconv.FormatUint(ts, 10)},
+		InfoField{"tso_time", physical.UTC().Format(time.RFC3339Nano)},
+	), nil
+}
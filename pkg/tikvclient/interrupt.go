@@ -0,0 +1,18 @@
+package tikvclient
+
+import (
+	"errors"
+)
+
+// errCanceled is returned by the operations which check the context between
+// their requests instead of waiting for them
+var errCanceled = errors.New("operation canceled")
+
+// interrupted returns errCanceled once the context of the operations is
+// canceled
+func (cli *TikvClient) interrupted() error {
+	if cli.context().Err() != nil {
+		return errCanceled
+	}
+	return nil
+}
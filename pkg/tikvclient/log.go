@@ -0,0 +1,35 @@
+package tikvclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogOff is the log level hiding the logs of the TiKV client
+const LogOff = "off"
+
+// logVisible is set once SetLogLevel shows the logs, Dial hides them otherwise
+var logVisible bool
+
+// SetLogLevel prints the logs of the TiKV and PD clients, like the region
+// cache and the backoffs, to stderr from the level on: debug, info, warn or
+// error. LogOff hides them, which Dial does unless this is called since they
+// would be mixed with the output.
+func SetLogLevel(level string) error {
+	if level == LogOff {
+		logVisible = false
+		logrus.SetOutput(ioutil.Discard)
+		return nil
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("unknown log level %q, should be debug, info, warn, error or off", level)
+	}
+	logVisible = true
+	logrus.SetOutput(os.Stderr)
+	logrus.SetLevel(lvl)
+	return nil
+}
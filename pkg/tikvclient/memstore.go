@@ -0,0 +1,195 @@
+package tikvclient
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pingcap/tidb/kv"
+)
+
+// Store is the part of kv.Storage the client uses, the store opened by Dial
+// is backed by TiKV and NewMemStore keeps the data in memory
+type Store interface {
+	Begin() (kv.Transaction, error)
+	GetSnapshot(ver kv.Version) (kv.Snapshot, error)
+	CurrentVersion() (kv.Version, error)
+	UUID() string
+	Close() error
+}
+
+// NewClient returns a transactional client on top of the store, the
+// operations needing the cluster itself like CommitTS or Info fail
+func NewClient(store Store) *TikvClient {
+	return &TikvClient{store: store, mode: ModeTxn, conflictRetries: DefaultConflictRetries}
+}
+
+// memVersion is a committed value of a key, a nil val is a deletion
+type memVersion struct {
+	ts  uint64
+	val []byte
+}
+
+// memStore is an in-memory multi-version store with optimistic transactions,
+// a commit fails with a write conflict if a key it writes was committed after
+// the transaction started
+type memStore struct {
+	mu       sync.Mutex
+	ts       uint64                  // the last allocated timestamp
+	versions map[string][]memVersion // in ascending ts
+}
+
+// NewMemStore returns an empty in-memory store to run the client without a
+// cluster, like in tests
+func NewMemStore() Store {
+	return &memStore{versions: make(map[string][]memVersion)}
+}
+
+// next allocates a timestamp, it has to be called with mu held
+func (s *memStore) next() uint64 {
+	s.ts++
+	return s.ts
+}
+
+func (s *memStore) Begin() (kv.Transaction, error) {
+	s.mu.Lock()
+	ts := s.next()
+	s.mu.Unlock()
+	snap, err := s.GetSnapshot(kv.NewVersion(ts))
+	if err != nil {
+		return nil, err
+	}
+	return &memTxn{UnionStore: kv.NewUnionStore(snap), store: s, startTS: ts, snap: snap, valid: true}, nil
+}
+
+// GetSnapshot copies the values visible at the version into a buffer
+func (s *memStore) GetSnapshot(ver kv.Version) (kv.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := kv.NewMemDbBuffer(kv.DefaultTxnMembufCap)
+	for key, vs := range s.versions {
+		i := sort.Search(len(vs), func(i int) bool { return vs[i].ts > ver.Ver })
+		if i == 0 || vs[i-1].val == nil {
+			continue
+		}
+		if err := buf.Set(kv.Key(key), vs[i-1].val); err != nil {
+			return nil, err
+		}
+	}
+	return &memSnapshot{buf}, nil
+}
+
+func (s *memStore) CurrentVersion() (kv.Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return kv.NewVersion(s.next()), nil
+}
+
+func (s *memStore) UUID() string {
+	return "memstore"
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+
+// commit applies the mutations if none of their keys was committed after
+// startTS, an empty value is a deletion
+func (s *memStore) commit(startTS uint64, mutations map[string][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range mutations {
+		if vs := s.versions[key]; len(vs) > 0 && vs[len(vs)-1].ts > startTS {
+			return fmt.Errorf("write conflict on %s, txn %d", DisplayKey([]byte(key)), startTS)
+		}
+	}
+	ts := s.next()
+	for key, val := range mutations {
+		v := memVersion{ts: ts}
+		if len(val) > 0 {
+			v.val = append([]byte{}, val...)
+		}
+		s.versions[key] = append(s.versions[key], v)
+	}
+	return nil
+}
+
+// memSnapshot is a read-only view of the store at a version
+type memSnapshot struct {
+	kv.MemBuffer
+}
+
+func (s *memSnapshot) BatchGet(keys []kv.Key) (map[string][]byte, error) {
+	m := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		val, err := s.Get(k)
+		if kv.IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[string(k)] = val
+	}
+	return m, nil
+}
+
+func (s *memSnapshot) SetPriority(priority int) {}
+
+// memTxn buffers the writes on top of the snapshot taken when it started
+type memTxn struct {
+	kv.UnionStore
+	store   *memStore
+	startTS uint64
+	snap    kv.Snapshot
+	valid   bool
+}
+
+func (t *memTxn) Commit(ctx context.Context) error {
+	if !t.valid {
+		return kv.ErrInvalidTxn
+	}
+	t.valid = false
+	mutations := make(map[string][]byte)
+	err := t.WalkBuffer(func(k kv.Key, v []byte) error {
+		mutations[string(k)] = v
+		return nil
+	})
+	if err != nil || len(mutations) == 0 {
+		return err
+	}
+	return t.store.commit(t.startTS, mutations)
+}
+
+func (t *memTxn) Rollback() error {
+	if !t.valid {
+		return kv.ErrInvalidTxn
+	}
+	t.valid = false
+	return nil
+}
+
+func (t *memTxn) String() string {
+	return fmt.Sprintf("%d", t.startTS)
+}
+
+func (t *memTxn) LockKeys(keys ...kv.Key) error {
+	return nil
+}
+
+func (t *memTxn) IsReadOnly() bool {
+	return t.Len() == 0
+}
+
+func (t *memTxn) StartTS() uint64 {
+	return t.startTS
+}
+
+func (t *memTxn) Valid() bool {
+	return t.valid
+}
+
+func (t *memTxn) GetSnapshot() kv.Snapshot {
+	return t.snap
+}
@@ -0,0 +1,94 @@
+package tikvclient
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the metrics of the operations, they are registered to the default registry
+// along with the ones of the TiKV client but only collected after SetMetrics
+var (
+	operationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tikv_cli",
+			Name:      "operations_total",
+			Help:      "Counter of the operations by type and result.",
+		}, []string{"op", "result"})
+
+	operationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "tikv_cli",
+			Name:      "operation_duration_seconds",
+			Help:      "Bucketed histogram of the duration of the operations, retries included.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 20),
+		}, []string{"op"})
+
+	readBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tikv_cli",
+			Name:      "read_bytes_total",
+			Help:      "Counter of the bytes of the keys and values read.",
+		})
+
+	writtenBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tikv_cli",
+			Name:      "written_bytes_total",
+			Help:      "Counter of the bytes of the keys and values written.",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(operationCounter)
+	prometheus.MustRegister(operationDuration)
+	prometheus.MustRegister(readBytes)
+	prometheus.MustRegister(writtenBytes)
+}
+
+// SetMetrics turns the collection of the operation metrics on or off, off by
+// default so the operations only check the flag. The metrics are served by
+// the handlers of the default prometheus registry.
+func (cli *TikvClient) SetMetrics(on bool) {
+	cli.metrics = on
+}
+
+// observe records an operation started at begin which ended with err, the
+// result is ok or the code of the error
+func (cli *TikvClient) observe(op string, begin time.Time, err error) {
+	if !cli.metrics {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = CodeOf(err).String()
+	}
+	operationCounter.WithLabelValues(op, result).Inc()
+	operationDuration.WithLabelValues(op).Observe(time.Since(begin).Seconds())
+}
+
+// countRead records the bytes of the pairs read
+func (cli *TikvClient) countRead(key, val []byte) {
+	if cli.metrics {
+		readBytes.Add(float64(len(key) + len(val)))
+	}
+}
+
+// countWritten records the bytes of the pairs written
+func (cli *TikvClient) countWritten(key, val []byte) {
+	if cli.metrics {
+		writtenBytes.Add(float64(len(key) + len(val)))
+	}
+}
+
+// countingEach wraps the callback of a scan to record the bytes of the pairs
+// passed to it, the callback is returned as is without metrics
+func (cli *TikvClient) countingEach(each func(key, val []byte) bool) func(key, val []byte) bool {
+	if !cli.metrics {
+		return each
+	}
+	return func(key, val []byte) bool {
+		readBytes.Add(float64(len(key) + len(val)))
+		return each(key, val)
+	}
+}
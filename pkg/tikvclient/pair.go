@@ -0,0 +1,25 @@
+package tikvclient
+
+import (
+	"encoding/hex"
+	"strconv"
+)
+
+// HexKeysInErrors renders the keys in the errors and diagnostics as hex
+var HexKeysInErrors bool
+
+// DisplayKey formats a key for error and diagnostic messages, it is quoted
+// unless HexKeysInErrors which renders any key unambiguously
+func DisplayKey(key []byte) string {
+	if HexKeysInErrors {
+		return "<hex:" + hex.EncodeToString(key) + ">"
+	}
+	return strconv.Quote(string(key))
+}
+
+// Pair is the JSON representation of a key/value pair, []byte fields are
+// encoded as base64 so binary data survives the round trip
+type Pair struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
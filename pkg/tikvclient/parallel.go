@@ -0,0 +1,41 @@
+package tikvclient
+
+// splitRange splits [begin, end) into at most n sub-ranges on the first byte
+// following their common prefix, an empty end means the end of the keyspace
+func splitRange(begin, end []byte, n int) [][2][]byte {
+	var common int
+	for common < len(begin) && common < len(end) && begin[common] == end[common] {
+		common++
+	}
+	lo, hi := 0, 256
+	if common < len(begin) {
+		lo = int(begin[common])
+	}
+	if len(end) > 0 {
+		hi = 0
+		if common < len(end) {
+			hi = int(end[common])
+		}
+	}
+	if hi-lo == 1 && common == len(begin)-1 {
+		// a prefix range like [user:, user;), split on the byte after the prefix
+		return cutRange(begin, end, begin, 0, 256, n)
+	}
+	return cutRange(begin, end, begin[:common], lo, hi, n)
+}
+
+// cutRange cuts [begin, end) at the points base+byte(b) for b evenly picked in (lo, hi)
+func cutRange(begin, end, base []byte, lo, hi, n int) [][2][]byte {
+	step := (hi - lo) / n
+	if step <= 0 || n <= 1 {
+		return [][2][]byte{{begin, end}}
+	}
+	var ranges [][2][]byte
+	start := begin
+	for i := 1; i < n; i++ {
+		point := append(append([]byte{}, base...), byte(lo+i*step))
+		ranges = append(ranges, [2][]byte{start, point})
+		start = point
+	}
+	return append(ranges, [2][]byte{start, end})
+}
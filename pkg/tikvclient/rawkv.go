@@ -0,0 +1,155 @@
+package tikvclient
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/pingcap/tidb/kv"
+)
+
+// the connect modes of Dial
+const (
+	ModeTxn  = "txn"
+	ModeRaw  = "raw"
+	ModeAuto = "auto"
+)
+
+// rawScanBatch is the number of pairs fetched by a raw scan request
+const rawScanBatch = 256
+
+// detectMode returns the mode of Dial, txn and raw are kept without probing
+// and auto is picked by the data of the keyspace. Transactional data lives in
+// the write column family which the raw API never reads, and raw data is
+// invisible to transactions, so txn is picked if a transaction sees any key
+// and raw if only the raw API does. The raw data is only probed when there is
+// no transactional one.
+func detectMode(mode string, hasTxnData, hasRawData func() (bool, error)) (string, error) {
+	switch mode {
+	case ModeTxn, ModeRaw:
+		return mode, nil
+	case ModeAuto:
+	default:
+		return "", fmt.Errorf("unknown connect mode %q, should be txn, raw or auto", mode)
+	}
+	found, err := hasTxnData()
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return ModeTxn, nil
+	}
+	if found, err = hasRawData(); err != nil {
+		return "", err
+	}
+	if found {
+		return ModeRaw, nil
+	}
+	fmt.Fprintln(os.Stderr, "connect mode auto: no key found, falling back to txn")
+	return ModeTxn, nil
+}
+
+// hasTxnData reports whether a transaction sees any key of the keyspace
+func (cli *TikvClient) hasTxnData() (bool, error) {
+	txn, err := cli.store.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback()
+	iter, err := txn.Seek(cli.key(nil))
+	if err != nil {
+		return false, err
+	}
+	defer iter.Close()
+	return iter.Valid() && bytes.HasPrefix(iter.Key(), cli.keyspace), nil
+}
+
+// hasRawData reports whether the raw API sees any key of the keyspace
+func (cli *TikvClient) hasRawData() (bool, error) {
+	keys, _, err := cli.raw.Scan(cli.key(nil), 1)
+	if err != nil {
+		return false, err
+	}
+	return len(keys) > 0 && bytes.HasPrefix(keys[0], cli.keyspace), nil
+}
+
+// txnOnly fails the operation in raw mode
+func (cli *TikvClient) txnOnly(op string) error {
+	if cli.raw != nil {
+		return fmt.Errorf("%s is not supported in raw mode, connect with --connect-mode txn", op)
+	}
+	return nil
+}
+
+// rawGetMany reads the keys one by one, they are not read from one snapshot
+func (cli *TikvClient) rawGetMany(keys [][]byte) ([][]byte, error) {
+	vals := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		// a missing key has a nil value
+		val, err := cli.raw.Get(cli.key(key))
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
+// rawBatchDelete deletes the existing keys one by one, it returns the pairs
+// deleted before any failure
+func (cli *TikvClient) rawBatchDelete(keys [][]byte) ([]Pair, error) {
+	var deleted []Pair
+	for _, key := range keys {
+		val, err := cli.raw.Get(cli.key(key))
+		if err != nil {
+			return deleted, err
+		}
+		if val == nil {
+			continue
+		}
+		if err := cli.raw.Delete(cli.key(key)); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, Pair{Key: key, Value: val})
+	}
+	return deleted, nil
+}
+
+// rawScan works like Scan in batches of rawScanBatch pairs, the deletes are
+// applied immediately since there is no transaction
+func (cli *TikvClient) rawScan(begin, end []byte, limit int64, deleteIf func(key []byte) bool, each func(key, val []byte) bool) (int64, error) {
+	start := []byte(cli.key(begin))
+	var count int64
+	for limit != 0 {
+		var keys, vals [][]byte
+		if err := cli.wait(func() (err error) {
+			keys, vals, err = cli.raw.Scan(start, rawScanBatch)
+			return err
+		}); err != nil {
+			return count, err
+		}
+		for i, key := range keys {
+			if limit == 0 || !bytes.HasPrefix(key, cli.keyspace) {
+				return count, nil
+			}
+			if len(end) > 0 && bytes.Compare(key[len(cli.keyspace):], end) >= 0 {
+				return count, nil
+			}
+			if !each(key[len(cli.keyspace):], vals[i]) {
+				return count, nil
+			}
+			if deleteIf != nil && deleteIf(key[len(cli.keyspace):]) {
+				if err := cli.raw.Delete(key); err != nil {
+					return count, err
+				}
+			}
+			count++
+			limit--
+		}
+		if len(keys) < rawScanBatch {
+			break
+		}
+		start = kv.Key(keys[len(keys)-1]).Next()
+	}
+	return count, nil
+}
@@ -0,0 +1,95 @@
+package tikvclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// probe returns a probe of the data which answers found and counts its calls
+func probe(found bool, calls *int) func() (bool, error) {
+	return func() (bool, error) {
+		*calls++
+		return found, nil
+	}
+}
+
+// captureStderr runs fn and returns what it printed to stderr
+func captureStderr(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = saved }()
+	fn()
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	return string(out)
+}
+
+func TestDetectMode(t *testing.T) {
+	for _, c := range []struct {
+		mode     string
+		txnData  bool
+		rawData  bool
+		want     string
+		probes   string // the probes called, t for txn and r for raw
+		fallback bool
+	}{
+		{ModeTxn, false, true, ModeTxn, "", false},
+		{ModeRaw, true, false, ModeRaw, "", false},
+		{ModeAuto, true, true, ModeTxn, "t", false},
+		{ModeAuto, false, true, ModeRaw, "tr", false},
+		{ModeAuto, false, false, ModeTxn, "tr", true},
+	} {
+		var txnCalls, rawCalls int
+		var mode string
+		var err error
+		stderr := captureStderr(t, func() {
+			mode, err = detectMode(c.mode, probe(c.txnData, &txnCalls), probe(c.rawData, &rawCalls))
+		})
+		probes := strings.Repeat("t", txnCalls) + strings.Repeat("r", rawCalls)
+		if err != nil || mode != c.want || probes != c.probes {
+			t.Errorf("%s with txn data %v and raw data %v: got %q, %v probing %q, want %q probing %q",
+				c.mode, c.txnData, c.rawData, mode, err, probes, c.want, c.probes)
+		}
+		if fallback := strings.Contains(stderr, "falling back to txn"); fallback != c.fallback {
+			t.Errorf("%s: got %q on stderr", c.mode, stderr)
+		}
+	}
+
+	// a failed probe fails the detection
+	failed := func() (bool, error) { return false, errors.New("unreachable") }
+	if _, err := detectMode(ModeAuto, failed, failed); err == nil {
+		t.Fatal("a failed probe was ignored")
+	}
+	for _, mode := range []string{"", "Txn", "rawkv"} {
+		if _, err := detectMode(mode, failed, failed); err == nil || !strings.Contains(err.Error(), "unknown connect mode") {
+			t.Errorf("%q: got %v, want an unknown mode", mode, err)
+		}
+	}
+	// Dial rejects a bad mode before connecting to anything
+	if _, err := Dial("tikv://127.0.0.1:1", "bogus"); err == nil || !strings.Contains(err.Error(), "unknown connect mode") {
+		t.Fatalf("got %v, want an unknown mode", err)
+	}
+}
+
+func TestHasTxnData(t *testing.T) {
+	cli := newTestClient(t)
+	if found, err := cli.hasTxnData(); err != nil || found {
+		t.Fatalf("got %v, %v on an empty store", found, err)
+	}
+	setPairs(t, cli, "b", "1")
+	cli.SetKeyspace([]byte("a"))
+	if found, err := cli.hasTxnData(); err != nil || found {
+		t.Fatalf("got %v, %v, the key is out of the keyspace", found, err)
+	}
+	cli.SetKeyspace([]byte("b"))
+	if found, err := cli.hasTxnData(); err != nil || !found {
+		t.Fatalf("got %v, %v, want the key found", found, err)
+	}
+}
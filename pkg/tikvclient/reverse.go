@@ -0,0 +1,78 @@
+package tikvclient
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+// locateMaxBackoff is the max backoff in milliseconds of locating a region
+const locateMaxBackoff = 20000
+
+// reverseChunkStart returns the start of the chunk read by a reverse scan
+// before upper, it is the start of the region holding the keys right before
+// upper so a chunk is at most about one region. An empty upper is the end of
+// the keyspace, the chunks never start before lower.
+func (cli *TikvClient) reverseChunkStart(upper, lower kv.Key) (kv.Key, error) {
+	store, ok := cli.store.(tikv.Storage)
+	if !ok {
+		return lower, nil
+	}
+	// any key below upper is in the region of the chunk, the closest one keeps
+	// the chunk small
+	probe := kv.Key{0xff}
+	if len(upper) > 0 {
+		probe = append(kv.Key{}, upper...)
+		if last := len(probe) - 1; probe[last] == 0 {
+			probe = probe[:last]
+		} else {
+			probe[last]--
+		}
+	}
+	if bytes.Compare(probe, lower) <= 0 {
+		return lower, nil
+	}
+	loc, err := store.GetRegionCache().LocateKey(tikv.NewBackoffer(context.Background(), locateMaxBackoff), probe)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Compare(loc.StartKey, lower) < 0 {
+		return lower, nil
+	}
+	return loc.StartKey, nil
+}
+
+// lastPairs scans [start, upper) forward and returns its last limit pairs in
+// ascending order, or all of them if limit is negative
+func lastPairs(snap kv.Snapshot, start, upper kv.Key, limit int64) ([]Pair, error) {
+	if limit == 0 {
+		return nil, nil
+	}
+	iter, err := snap.Seek(start)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var pairs []Pair
+	var next int // the oldest pair once the ring of limit pairs is full
+	for iter.Valid() {
+		if len(upper) > 0 && bytes.Compare(iter.Key(), upper) >= 0 {
+			break
+		}
+		p := Pair{Key: append([]byte{}, iter.Key()...), Value: append([]byte{}, iter.Value()...)}
+		if limit < 0 || int64(len(pairs)) < limit {
+			pairs = append(pairs, p)
+		} else {
+			pairs[next] = p
+			next = (next + 1) % len(pairs)
+		}
+		if err := iter.Next(); err != nil {
+			return nil, err
+		}
+	}
+	ordered := make([]Pair, 0, len(pairs))
+	return append(append(ordered, pairs[next:]...), pairs[:next]...), nil
+}
@@ -0,0 +1,59 @@
+package tikvclient
+
+import (
+	"fmt"
+
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+// SetSnapshot makes the reads of Get, GetMany and the scans see the data as
+// of the timestamp, zero reads the latest data. The reads at a timestamp are
+// read-only, they can not delete.
+func (cli *TikvClient) SetSnapshot(ts uint64) {
+	cli.snapshotTS = ts
+}
+
+// readVersion returns the version the reads see, a timestamp of SetSnapshot
+// is checked against the GC safe point since the versions before it may be
+// gone
+func (cli *TikvClient) readVersion() (kv.Version, error) {
+	if cli.snapshotTS == 0 {
+		return cli.store.CurrentVersion()
+	}
+	if err := cli.txnOnly("reading a snapshot"); err != nil {
+		return kv.Version{}, err
+	}
+	if store, ok := cli.store.(interface{ CheckVisibility(ts uint64) error }); ok {
+		if err := store.CheckVisibility(cli.snapshotTS); err != nil {
+			cli.explainGC(&err)
+			return kv.Version{}, err
+		}
+	}
+	return kv.Version{Ver: cli.snapshotTS}, nil
+}
+
+// snapshot returns the read-only snapshot of SetSnapshot
+func (cli *TikvClient) snapshot() (kv.Snapshot, error) {
+	ver, err := cli.readVersion()
+	if err != nil {
+		return nil, err
+	}
+	return cli.store.GetSnapshot(ver)
+}
+
+// explainGC replaces the error of a read whose versions were garbage collected
+// with what to do about it, it is meant to be deferred before classifyError.
+// The TiKV client checks the start timestamp of a read against the GC safe
+// point before every batch, so a scan running longer than the GC life time
+// fails halfway even without a snapshot.
+func (cli *TikvClient) explainGC(err *error) {
+	if !tikv.ErrGCTooEarly.Equal(*err) {
+		return
+	}
+	if cli.snapshotTS != 0 {
+		*err = fmt.Errorf("the snapshot %d was garbage collected; rerun without --snapshot or increase the GC life time", cli.snapshotTS)
+		return
+	}
+	*err = fmt.Errorf("the operation ran longer than the GC life time and the versions it reads were garbage collected; increase the GC life time or read a smaller range at once")
+}
@@ -0,0 +1,87 @@
+package tikvclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// abandonedError is returned when an operation is not waited for anymore
+// because it timed out or was canceled, it may still be running in the
+// background
+type abandonedError struct {
+	timeout time.Duration // zero if canceled
+}
+
+func (e *abandonedError) Error() string {
+	if e.timeout == 0 {
+		return "operation canceled"
+	}
+	return fmt.Sprintf("operation timed out after %v", e.timeout)
+}
+
+// isAbandoned reports whether the operation of the error may still be running
+func isAbandoned(err error) bool {
+	_, ok := err.(*abandonedError)
+	return ok
+}
+
+// SetTimeout bounds every request of Get, Set, Delete and Scan and the like
+// to d, zero waits forever
+func (cli *TikvClient) SetTimeout(d time.Duration) {
+	cli.timeout = d
+}
+
+// SetContext sets the context of the following operations, canceling it
+// stops waiting for them
+func (cli *TikvClient) SetContext(ctx context.Context) {
+	cli.ctx = ctx
+}
+
+// Context returns the context set by SetContext, never nil
+func (cli *TikvClient) Context() context.Context {
+	return cli.context()
+}
+
+// context returns the context of the operations, never nil
+func (cli *TikvClient) context() context.Context {
+	if cli.ctx == nil {
+		return context.Background()
+	}
+	return cli.ctx
+}
+
+// wait runs f and waits for it until the timeout or the cancellation of the
+// context. The vendored client takes no context for reads, so f keeps running
+// in the background if it is abandoned, and the caller must not touch what f
+// uses after an abandonedError.
+func (cli *TikvClient) wait(f func() error) error {
+	ctx := cli.context()
+	if cli.timeout == 0 && ctx.Done() == nil {
+		return f()
+	}
+	if cli.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cli.timeout)
+		defer cancel()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// a finished operation is not abandoned
+		select {
+		case err := <-done:
+			return err
+		default:
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return &abandonedError{timeout: cli.timeout}
+		}
+		return &abandonedError{}
+	}
+}
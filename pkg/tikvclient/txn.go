@@ -0,0 +1,84 @@
+package tikvclient
+
+import (
+	"fmt"
+
+	"github.com/pingcap/tidb/kv"
+)
+
+// Begin starts an explicit transaction, the following operations run in it
+// and are neither committed nor rolled back until Commit or Rollback
+func (cli *TikvClient) Begin() error {
+	if err := cli.txnOnly("begin"); err != nil {
+		return err
+	}
+	if cli.txn != nil {
+		return fmt.Errorf("a transaction is already active, commit or rollback first")
+	}
+	txn, err := cli.store.Begin()
+	if err != nil {
+		return classify(err)
+	}
+	cli.txn = txn
+	return nil
+}
+
+// Commit commits the explicit transaction
+func (cli *TikvClient) Commit() error {
+	if cli.txn == nil {
+		return fmt.Errorf("no transaction is active")
+	}
+	txn := cli.txn
+	cli.txn = nil
+	return classify(cli.wait(func() error { return txn.Commit(cli.context()) }))
+}
+
+// Rollback discards the explicit transaction
+func (cli *TikvClient) Rollback() error {
+	if cli.txn == nil {
+		return fmt.Errorf("no transaction is active")
+	}
+	txn := cli.txn
+	cli.txn = nil
+	return classify(txn.Rollback())
+}
+
+// InTxn reports whether an explicit transaction is active
+func (cli *TikvClient) InTxn() bool {
+	return cli.txn != nil
+}
+
+// begin returns the explicit transaction if there is one, or a new one
+func (cli *TikvClient) begin() (kv.Transaction, error) {
+	if cli.txn != nil {
+		return cli.txn, nil
+	}
+	return cli.store.Begin()
+}
+
+// commit commits a transaction returned by begin, the explicit one is left
+// to Commit
+func (cli *TikvClient) commit(txn kv.Transaction) error {
+	if txn == cli.txn {
+		return nil
+	}
+	return txn.Commit(cli.context())
+}
+
+// rollback rolls back a transaction returned by begin, the explicit one is
+// left to Rollback
+func (cli *TikvClient) rollback(txn kv.Transaction) error {
+	if txn == cli.txn {
+		return nil
+	}
+	return txn.Rollback()
+}
+
+// autoCommitOnly fails the operations which use transactions of their own,
+// they can not run in an explicit transaction
+func (cli *TikvClient) autoCommitOnly(op string) error {
+	if cli.txn != nil {
+		return fmt.Errorf("%s can not be used in a transaction, commit or rollback first", op)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// maxSourceDepth bounds the nesting of source, so a script sourcing itself
+// fails instead of recursing forever
+const maxSourceDepth = 16
+
+// tokenize splits a line into its statements separated by ';' and the
+// statements into their arguments separated by spaces or tabs. Single quotes
+// keep everything up to the closing quote, double quotes keep everything but
+// \" which is a quote, and out of quotes a backslash keeps the following
+// space, tab, quote or ';'. The other backslashes are kept for the escape
+// encoding of the keys and values, so "a\x20b" is still decoded as "a b".
+func tokenize(line string) ([][]string, error) {
+	var stmts [][]string
+	var args []string
+	var arg []byte
+	var inArg bool // an empty quoted argument is still an argument
+	endArg := func() {
+		if inArg {
+			args = append(args, string(arg))
+		}
+		arg, inArg = nil, false
+	}
+	endStmt := func() {
+		endArg()
+		if len(args) > 0 {
+			stmts = append(stmts, args)
+		}
+		args = nil
+	}
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; ch {
+		case ' ', '\t':
+			endArg()
+		case ';':
+			endStmt()
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' quote")
+			}
+			arg, inArg = append(arg, line[i+1:i+1+end]...), true
+			i += end + 1
+		case '"':
+			inArg = true
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, fmt.Errorf("unterminated \" quote")
+				}
+				if line[i] == '"' {
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '"' {
+					i++
+				}
+				arg = append(arg, line[i])
+			}
+		case '\\':
+			if i+1 < len(line) && strings.IndexByte(" \t'\";", line[i+1]) >= 0 {
+				i++
+			}
+			arg, inArg = append(arg, line[i]), true
+		default:
+			arg, inArg = append(arg, ch), true
+		}
+	}
+	endStmt()
+	return stmts, nil
+}
+
+// runLine runs the statements of a line in order, it stops at the first
+// failing one and reports whether all of them succeeded
+func runLine(c *command, line string) bool {
+	stmts, err := tokenize(line)
+	if err != nil {
+		c.printError(err)
+		return false
+	}
+	for _, args := range stmts {
+		failures := c.failures
+		processArgs(c, args)
+		if c.failures != failures {
+			return false
+		}
+	}
+	return true
+}
+
+// source runs the lines of a script file like the lines of the shell, the
+// empty lines and the ones starting with # are skipped. It stops at the first
+// failing line unless --continue-on-error.
+func (c *command) source(args []string) {
+	if len(args) != 1 {
+		c.printError(fmt.Errorf("script file is required"))
+		return
+	}
+	if c.sourceDepth >= maxSourceDepth {
+		c.printError(fmt.Errorf("source is nested more than %d times", maxSourceDepth))
+		return
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		c.printError(err)
+		return
+	}
+	defer f.Close()
+	// the options of a nested source are parsed again by its own line
+	continueOnError := c.sourceOpts.continueOnError
+	c.sourceDepth++
+	defer func() { c.sourceDepth-- }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !runLine(c, line) && !continueOnError {
+			fmt.Fprintf(os.Stderr, "%s:%d: stopped at the failing line\n", args[0], n)
+			return
+		}
+		if err := c.cli.Context().Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: stopped, the script is canceled\n", args[0], n)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		c.printError(fmt.Errorf("%s:%d: %v", args[0], n+1, err))
+	}
+}
+
+func (c *command) sourceFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&c.sourceOpts.continueOnError, "continue-on-error", false, "run the following lines after a line failed")
+}
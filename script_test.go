@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		want string // the statements formatted with %q, empty for an error
+	}{
+		{`set k v`, `[["set" "k" "v"]]`},
+		{`  set   k	v  `, `[["set" "k" "v"]]`},
+		{`set mykey "hello world"`, `[["set" "mykey" "hello world"]]`},
+		{`set 'my key' 'it"s'`, `[["set" "my key" "it\"s"]]`},
+		{`set k "say \"hi\""`, `[["set" "k" "say \"hi\""]]`},
+		{`set k "a\x00"`, `[["set" "k" "a\\x00"]]`},
+		{`set my\ key v\;w`, `[["set" "my key" "v;w"]]`},
+		{`set k \'`, `[["set" "k" "'"]]`},
+		{`set k ""`, `[["set" "k" ""]]`},
+		{`set k a"b c"d`, `[["set" "k" "ab cd"]]`},
+		{`set k v; get k;;`, `[["set" "k" "v"] ["get" "k"]]`},
+		{`set k "v;w"`, `[["set" "k" "v;w"]]`},
+		{`get k\`, `[["get" "k\\"]]`},
+		{``, `[]`},
+		{`set k "v`, ""},
+		{`set k 'v`, ""},
+		{`set k "v\"`, ""},
+	} {
+		stmts, err := tokenize(c.line)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", c.line, stmts)
+			}
+			continue
+		}
+		if got := fmt.Sprintf("%q", stmts); err != nil || got != c.want {
+			t.Errorf("%s: got %s, %v, want %s", c.line, got, err, c.want)
+		}
+	}
+
+	cmd := newTestCommand(t)
+	run(t, cmd, `set "my key" "hello world"`)
+	if val := mustGet(t, cmd, "my key"); string(val) != "hello world" {
+		t.Fatalf("got %q", val)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// minSizeFilter passes the pairs whose value has at least min bytes
+func minSizeFilter(min int) scanFilter {
+	return func(key, val []byte) (bool, error) {
+		return len(val) >= min, nil
+	}
+}
+
+// sizeWriter prints every pair with the sizes of its key and value
+type sizeWriter struct {
+	w   io.Writer
+	enc *json.Encoder // nil for the text output
+}
+
+// newSizeWriter creates the writer of scan --show-size, only the text and
+// JSON outputs have a place for the sizes
+func newSizeWriter(w io.Writer, opts *Options) (*sizeWriter, error) {
+	if opts.tmpl != nil || opts.KeySplit != "" || opts.Output != "text" && opts.Output != "json" {
+		return nil, fmt.Errorf("--show-size can only be used with --output text or json, see also ndjson-with-meta")
+	}
+	if opts.Output == "json" {
+		return &sizeWriter{w: w, enc: json.NewEncoder(w)}, nil
+	}
+	return &sizeWriter{w: w}, nil
+}
+
+func (sw *sizeWriter) Write(key, val []byte) error {
+	if sw.enc != nil {
+		return sw.enc.Encode(struct {
+			Key       []byte `json:"key"`
+			Value     []byte `json:"value"`
+			KeySize   int    `json:"key_size"`
+			ValueSize int    `json:"value_size"`
+		}{key, val, len(key), len(val)})
+	}
+	_, err := fmt.Fprintf(sw.w, "%q:%q (key %d, value %d bytes)\n", string(key), string(val), len(key), len(val))
+	return err
+}
+
+func (sw *sizeWriter) Flush() error {
+	return nil
+}
+
+// topSizeWriter keeps the n pairs with the largest values and writes them
+// largest first on Flush, so only n pairs are held whatever the scanned range
+type topSizeWriter struct {
+	outputWriter
+	n     int
+	pairs pairsBySize // a min-heap of the values sizes
+}
+
+func (tw *topSizeWriter) Write(key, val []byte) error {
+	if len(tw.pairs) == tw.n {
+		if len(val) <= len(tw.pairs[0].Value) {
+			return nil
+		}
+		heap.Pop(&tw.pairs)
+	}
+	heap.Push(&tw.pairs, kvPair{Key: append([]byte{}, key...), Value: append([]byte{}, val...)})
+	return nil
+}
+
+func (tw *topSizeWriter) Flush() error {
+	sort.Sort(sort.Reverse(tw.pairs))
+	for _, p := range tw.pairs {
+		if err := tw.outputWriter.Write(p.Key, p.Value); err != nil {
+			return err
+		}
+	}
+	tw.pairs = nil
+	return tw.outputWriter.Flush()
+}
+
+// pairsBySize orders the pairs by the size of their values, the smaller key
+// first among equal sizes
+type pairsBySize []kvPair
+
+func (ps pairsBySize) Len() int { return len(ps) }
+
+func (ps pairsBySize) Less(i, j int) bool {
+	if len(ps[i].Value) != len(ps[j].Value) {
+		return len(ps[i].Value) < len(ps[j].Value)
+	}
+	return string(ps[i].Key) > string(ps[j].Key)
+}
+
+func (ps pairsBySize) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+
+func (ps *pairsBySize) Push(x interface{}) { *ps = append(*ps, x.(kvPair)) }
+
+func (ps *pairsBySize) Pop() interface{} {
+	old := *ps
+	p := old[len(old)-1]
+	*ps = old[:len(old)-1]
+	return p
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"time"
+)
+
+// sizeHistogram estimates the quantiles of a stream of sizes in constant
+// memory. Sizes below 16 are counted exactly, larger ones in 16 buckets per
+// power of two, so an estimate is at most 1/16 below the exact quantile.
+type sizeHistogram struct {
+	buckets [16 + 60*16]int64
+	count   int64
+}
+
+func sizeBucket(n int) int {
+	if n < 16 {
+		return n
+	}
+	exp := bits.Len(uint(n)) - 1
+	return 16 + (exp-4)*16 + (n>>uint(exp-4))&15
+}
+
+// bucketLow returns the smallest size of the bucket
+func bucketLow(b int) int {
+	if b < 16 {
+		return b
+	}
+	exp := (b-16)/16 + 4
+	return (16 + (b-16)%16) << uint(exp-4)
+}
+
+func (h *sizeHistogram) add(n int) {
+	h.buckets[sizeBucket(n)]++
+	h.count++
+}
+
+// quantile returns the estimated q-quantile, 0 if nothing was added
+func (h *sizeHistogram) quantile(q float64) int {
+	rank := int64(q * float64(h.count))
+	if rank >= h.count {
+		rank = h.count - 1
+	}
+	var seen int64
+	for b, n := range h.buckets {
+		seen += n
+		if seen > rank {
+			return bucketLow(b)
+		}
+	}
+	return 0
+}
+
+// statsWriter collects the sizes of the pairs written through it
+type statsWriter struct {
+	outputWriter
+	begin  time.Time
+	values sizeHistogram
+	bytes  int64 // total size of the keys and values
+}
+
+func (sw *statsWriter) Write(key, val []byte) error {
+	sw.values.add(len(val))
+	sw.bytes += int64(len(key) + len(val))
+	return sw.outputWriter.Write(key, val)
+}
+
+// scanStats is the footer printed by --stats-footer
+type scanStats struct {
+	Pairs   int64  `json:"pairs"`
+	Bytes   int64  `json:"bytes"`
+	P50     int    `json:"value_size_p50"`
+	P90     int    `json:"value_size_p90"`
+	P99     int    `json:"value_size_p99"`
+	Elapsed string `json:"elapsed"`
+}
+
+func (sw *statsWriter) stats() *scanStats {
+	return &scanStats{
+		Pairs:   sw.values.count,
+		Bytes:   sw.bytes,
+		P50:     sw.values.quantile(0.5),
+		P90:     sw.values.quantile(0.9),
+		P99:     sw.values.quantile(0.99),
+		Elapsed: time.Since(sw.begin).String(),
+	}
+}
+
+// footer formats the stats as summary lines, or a single JSON object
+func (s *scanStats) footer(asJSON bool) []string {
+	if asJSON {
+		data, _ := json.Marshal(map[string]*scanStats{"stats": s})
+		return []string{string(data)}
+	}
+	return []string{
+		fmt.Sprintf("Total bytes %d", s.Bytes),
+		fmt.Sprintf("Value size p50 %d p90 %d p99 %d", s.P50, s.P90, s.P99),
+		fmt.Sprintf("Elapsed %s", s.Elapsed),
+	}
+}
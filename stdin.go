@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+// errStdinStopped stops reading stdin after the batch already reported its
+// error
+var errStdinStopped = errors.New("stopped")
+
+func (c *command) stdinFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&c.stdinOpts.stdin, "stdin", false, "read the keys from stdin, one per line, like a - argument")
+	fs.IntVar(&c.stdinOpts.batch, "batch", 256, "number of keys read from stdin in one transaction")
+}
+
+func (c *command) deleteFlags(fs *pflag.FlagSet) {
+	c.inputFlags(fs)
+	c.stdinFlags(fs)
+	fs.StringVar(&c.deleteOpts.prefix, "prefix", "", "delete all the keys with this prefix in transactions of --batch keys, like truncate")
+	fs.BoolVarP(&c.deleteOpts.yes, "yes", "y", false, "confirm the deletion of the --prefix, it is required")
+}
+
+// fromStdin reports whether the keys are read from stdin instead of the
+// arguments
+func (c *command) fromStdin(args []string) bool {
+	return c.stdinOpts.stdin || len(args) == 1 && args[0] == "-"
+}
+
+// readStdinKeys decodes the lines of stdin like the key arguments and calls fn
+// with every --batch keys, so a long list is not written in one giant
+// transaction. It returns the number of the lines read when it stopped.
+func (c *command) readStdinKeys(fn func(keys [][]byte) error) (int64, error) {
+	if c.stdinOpts.batch <= 0 {
+		return 0, fmt.Errorf("batch should be greater than 0")
+	}
+	return readKeyBatches(os.Stdin, c.stdinOpts.batch, c.decodeArgs, fn)
+}
+
+// readKeyBatches reads one key per line, the empty lines are skipped
+func readKeyBatches(r io.Reader, size int, decode func(args []string) ([][]byte, error), fn func(keys [][]byte) error) (int64, error) {
+	var line int64
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		keys, err := decode(batch)
+		if err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return fn(keys)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line++
+		if scanner.Text() == "" {
+			continue
+		}
+		batch = append(batch, scanner.Text())
+		if len(batch) < size {
+			continue
+		}
+		if err := flush(); err != nil {
+			return line, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return line, err
+	}
+	return line, flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+)
+
+// version is the version of the CLI, it is set when building a release by
+//
+//	go build -ldflags "-X main.version=v1.2.0"
+var version = "dev"
+
+// clientVersion is the version of the vendored pingcap/tidb client, it has to
+// be kept in sync with Gopkg.lock since GOPATH builds record no module info
+const clientVersion = "v2.0.6"
+
+// VersionInfo identifies the build of the CLI
+type VersionInfo struct {
+	Version       string `json:"version"`
+	GoVersion     string `json:"go_version"`
+	ClientVersion string `json:"client_version"` // version of pingcap/tidb
+	Platform      string `json:"platform"`
+}
+
+// Version returns the versions of the CLI and of what it is built with
+func Version() VersionInfo {
+	return VersionInfo{
+		Version:       version,
+		GoVersion:     runtime.Version(),
+		ClientVersion: clientVersion,
+		Platform:      runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+func (v VersionInfo) String() string {
+	return fmt.Sprintf("tikv-cli %s\ngo: %s %s\nclient: github.com/pingcap/tidb %s", v.Version, v.GoVersion, v.Platform, v.ClientVersion)
+}
+
+// printVersion prints the version of the CLI, as a JSON object with
+// --output json
+func (c *command) printVersion(args []string) {
+	if c.opts.Output == "json" {
+		out, _ := json.Marshal(Version())
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Println(Version())
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+	"github.com/spf13/pflag"
+)
+
+// watchTimeFormat is the timestamp of every change printed by watch
+const watchTimeFormat = "2006-01-02 15:04:05.000"
+
+// errWatchStop ends a watch after its poll reported why
+var errWatchStop = errors.New("watch stopped")
+
+// watch polls a key or the keys of a prefix until Ctrl-C and prints what
+// changed since the previous poll. Every poll is a read of its own, so no
+// transaction is held open between the polls.
+func (c *command) watch(args []string) {
+	if c.watchOpts.interval <= 0 {
+		c.printError(fmt.Errorf("--interval should be greater than 0"))
+		return
+	}
+	if c.cli.InTxn() {
+		c.printError(fmt.Errorf("watch can not be used in a transaction, it would never see the changes"))
+		return
+	}
+	var poll func() error
+	switch {
+	case c.watchOpts.prefix != "" && len(args) > 0:
+		c.printError(fmt.Errorf("watch takes a key or --prefix, not both"))
+		return
+	case c.watchOpts.prefix != "":
+		prefix, err := decodeArg(c.watchOpts.prefix, c.opts.KeyEncoding)
+		if err != nil {
+			c.printError(err)
+			return
+		}
+		if c.watchOpts.limit <= 0 {
+			c.printError(fmt.Errorf("--limit should be greater than 0"))
+			return
+		}
+		poll = c.watchPrefix(prefix)
+	case len(args) == 1:
+		key, err := c.decodeKey(args[0])
+		if err != nil {
+			c.printError(err)
+			return
+		}
+		poll = c.watchKey(key)
+	default:
+		c.printError(fmt.Errorf("watch takes a key or --prefix"))
+		return
+	}
+
+	ctx := c.cli.Context()
+	for {
+		if err := poll(); err != nil {
+			if ctx.Err() != nil || err == errWatchStop {
+				return
+			}
+			if isBrokenPipe(err) {
+				c.printError(err)
+				return
+			}
+			// a failed poll is reported and the next one tries again
+			c.printError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.watchOpts.interval):
+		}
+	}
+}
+
+// watchKey returns the poll of a single key, it prints the value on the first
+// poll and whenever it changes, (nil) if the key is missing
+func (c *command) watchKey(key []byte) func() error {
+	var last []byte
+	var polled, existed bool
+	return func() error {
+		val, err := c.cli.Get(key)
+		exists := err == nil
+		if err != nil && tikvclient.CodeOf(err) != tikvclient.ErrNotFound {
+			return err
+		}
+		if polled && exists == existed && bytes.Equal(val, last) {
+			return nil
+		}
+		polled, existed, last = true, exists, val
+		shown := missing
+		if exists {
+			shown = fmt.Sprintf("%q", val)
+		}
+		_, err = fmt.Printf("%s %q:%s\n", time.Now().Format(watchTimeFormat), key, shown)
+		return err
+	}
+}
+
+// watchPrefix returns the poll of the keys with the prefix, it prints the
+// added keys with +, the deleted ones with - and the modified ones with ~ in
+// key order. The keys found by the first poll are printed as added.
+func (c *command) watchPrefix(prefix []byte) func() error {
+	var last map[string][]byte
+	return func() error {
+		cur := make(map[string][]byte)
+		_, err := c.cli.ScanRange(prefix, prefixEnd(prefix), int64(c.watchOpts.limit)+1, nil, func(key, val []byte) bool {
+			cur[string(key)] = append([]byte{}, val...)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if len(cur) > c.watchOpts.limit {
+			c.printError(fmt.Errorf("the prefix holds more than %d keys, narrow it or raise --limit", c.watchOpts.limit))
+			return errWatchStop
+		}
+		keys := make([]string, 0, len(cur)+len(last))
+		for key := range cur {
+			keys = append(keys, key)
+		}
+		for key := range last {
+			if _, ok := cur[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		now := time.Now().Format(watchTimeFormat)
+		for _, key := range keys {
+			val, ok := cur[key]
+			old, existed := last[key]
+			var err error
+			switch {
+			case ok && !existed:
+				_, err = fmt.Printf("%s + %q:%q\n", now, key, val)
+			case !ok:
+				_, err = fmt.Printf("%s - %q\n", now, key)
+			case !bytes.Equal(val, old):
+				_, err = fmt.Printf("%s ~ %q:%q\n", now, key, val)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		last = cur
+		return nil
+	}
+}
+
+func (c *command) watchFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.watchOpts.prefix, "prefix", "", "watch the keys with this prefix instead of a single key")
+	fs.DurationVar(&c.watchOpts.interval, "interval", time.Second, "time between the polls")
+	fs.IntVar(&c.watchOpts.limit, "limit", 1000, "max number of keys under the --prefix, the watch stops beyond it")
+}
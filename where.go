@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/shafreeck/tikv-cli/pkg/tikvclient"
+)
+
+// whereType is the type of a --where expression
+type whereType int
+
+const (
+	whereInt whereType = iota
+	whereString
+	whereBool
+)
+
+func (t whereType) String() string {
+	return [...]string{"int", "string", "bool"}[t]
+}
+
+// wherePair is the pair a --where expression is evaluated against
+type wherePair struct {
+	key, val []byte
+}
+
+// whereExpr is a compiled expression, eval returns an int64, a string or a
+// bool depending on typ
+type whereExpr struct {
+	typ  whereType
+	eval func(p *wherePair) interface{}
+}
+
+// whereFuncs are the functions of --where expressions
+var whereFuncs = map[string]struct {
+	args []whereType
+	ret  whereType
+	call func(args []interface{}) interface{}
+}{
+	"len": {[]whereType{whereString}, whereInt, func(a []interface{}) interface{} {
+		return int64(len(a[0].(string)))
+	}},
+	"lower": {[]whereType{whereString}, whereString, func(a []interface{}) interface{} {
+		return strings.ToLower(a[0].(string))
+	}},
+	"contains": {[]whereType{whereString, whereString}, whereBool, func(a []interface{}) interface{} {
+		return strings.Contains(a[0].(string), a[1].(string))
+	}},
+	"hasPrefix": {[]whereType{whereString, whereString}, whereBool, func(a []interface{}) interface{} {
+		return strings.HasPrefix(a[0].(string), a[1].(string))
+	}},
+	"hasSuffix": {[]whereType{whereString, whereString}, whereBool, func(a []interface{}) interface{} {
+		return strings.HasSuffix(a[0].(string), a[1].(string))
+	}},
+}
+
+// compileWhere parses the expression with the Go syntax and checks its
+// types, it has to be a bool
+func compileWhere(src string) (*whereExpr, error) {
+	node, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --where: %v", err)
+	}
+	e, err := compileNode(node)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --where: %v", err)
+	}
+	if e.typ != whereBool {
+		return nil, fmt.Errorf("invalid --where: the expression is %v, should be bool", e.typ)
+	}
+	return e, nil
+}
+
+func compileNode(node ast.Expr) (*whereExpr, error) {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		return compileNode(n.X)
+	case *ast.Ident:
+		return compileIdent(n.Name)
+	case *ast.BasicLit:
+		switch n.Kind {
+		case token.INT:
+			v, err := strconv.ParseInt(n.Value, 0, 64)
+			if err != nil {
+				return nil, err
+			}
+			return &whereExpr{whereInt, func(*wherePair) interface{} { return v }}, nil
+		case token.STRING:
+			v, err := strconv.Unquote(n.Value)
+			if err != nil {
+				return nil, err
+			}
+			return &whereExpr{whereString, func(*wherePair) interface{} { return v }}, nil
+		}
+		return nil, fmt.Errorf("unsupported literal %s", n.Value)
+	case *ast.UnaryExpr:
+		x, err := compileNode(n.X)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case n.Op == token.NOT && x.typ == whereBool:
+			return &whereExpr{whereBool, func(p *wherePair) interface{} { return !x.eval(p).(bool) }}, nil
+		case n.Op == token.SUB && x.typ == whereInt:
+			return &whereExpr{whereInt, func(p *wherePair) interface{} { return -x.eval(p).(int64) }}, nil
+		}
+		return nil, fmt.Errorf("operator %s is not defined on %v", n.Op, x.typ)
+	case *ast.BinaryExpr:
+		return compileBinary(n)
+	case *ast.CallExpr:
+		return compileCall(n)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", node)
+}
+
+func compileIdent(name string) (*whereExpr, error) {
+	switch name {
+	case "key":
+		return &whereExpr{whereString, func(p *wherePair) interface{} { return string(p.key) }}, nil
+	case "value":
+		return &whereExpr{whereString, func(p *wherePair) interface{} { return string(p.val) }}, nil
+	case "keyLen":
+		return &whereExpr{whereInt, func(p *wherePair) interface{} { return int64(len(p.key)) }}, nil
+	case "valueLen":
+		return &whereExpr{whereInt, func(p *wherePair) interface{} { return int64(len(p.val)) }}, nil
+	case "true", "false":
+		v := name == "true"
+		return &whereExpr{whereBool, func(*wherePair) interface{} { return v }}, nil
+	}
+	return nil, fmt.Errorf("unknown variable %s", name)
+}
+
+func compileBinary(n *ast.BinaryExpr) (*whereExpr, error) {
+	x, err := compileNode(n.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := compileNode(n.Y)
+	if err != nil {
+		return nil, err
+	}
+	if x.typ != y.typ {
+		return nil, fmt.Errorf("mismatched types %v and %v of %s", x.typ, y.typ, n.Op)
+	}
+	switch n.Op {
+	case token.LAND, token.LOR:
+		if x.typ != whereBool {
+			break
+		}
+		and := n.Op == token.LAND
+		return &whereExpr{whereBool, func(p *wherePair) interface{} {
+			if x.eval(p).(bool) == and {
+				return y.eval(p).(bool)
+			}
+			return !and
+		}}, nil
+	case token.EQL, token.NEQ:
+		eq := n.Op == token.EQL
+		return &whereExpr{whereBool, func(p *wherePair) interface{} { return (x.eval(p) == y.eval(p)) == eq }}, nil
+	case token.LSS, token.LEQ, token.GTR, token.GEQ:
+		if x.typ == whereBool {
+			break
+		}
+		op := n.Op
+		return &whereExpr{whereBool, func(p *wherePair) interface{} {
+			var cmp int
+			if x.typ == whereInt {
+				a, b := x.eval(p).(int64), y.eval(p).(int64)
+				if a < b {
+					cmp = -1
+				} else if a > b {
+					cmp = 1
+				}
+			} else {
+				cmp = strings.Compare(x.eval(p).(string), y.eval(p).(string))
+			}
+			switch op {
+			case token.LSS:
+				return cmp < 0
+			case token.LEQ:
+				return cmp <= 0
+			case token.GTR:
+				return cmp > 0
+			}
+			return cmp >= 0
+		}}, nil
+	case token.ADD:
+		if x.typ == whereString {
+			return &whereExpr{whereString, func(p *wherePair) interface{} { return x.eval(p).(string) + y.eval(p).(string) }}, nil
+		}
+		fallthrough
+	case token.SUB, token.MUL, token.QUO, token.REM:
+		if x.typ != whereInt {
+			break
+		}
+		op := n.Op
+		return &whereExpr{whereInt, func(p *wherePair) interface{} {
+			a, b := x.eval(p).(int64), y.eval(p).(int64)
+			switch op {
+			case token.ADD:
+				return a + b
+			case token.SUB:
+				return a - b
+			case token.MUL:
+				return a * b
+			case token.QUO:
+				return a / b
+			}
+			return a % b
+		}}, nil
+	}
+	return nil, fmt.Errorf("operator %s is not defined on %v", n.Op, x.typ)
+}
+
+func compileCall(n *ast.CallExpr) (*whereExpr, error) {
+	ident, ok := n.Fun.(*ast.Ident)
+	if !ok {
+		return nil, fmt.Errorf("unsupported call")
+	}
+	f, ok := whereFuncs[ident.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", ident.Name)
+	}
+	if len(n.Args) != len(f.args) {
+		return nil, fmt.Errorf("%s takes %d arguments", ident.Name, len(f.args))
+	}
+	args := make([]*whereExpr, len(n.Args))
+	for i, arg := range n.Args {
+		a, err := compileNode(arg)
+		if err != nil {
+			return nil, err
+		}
+		if a.typ != f.args[i] {
+			return nil, fmt.Errorf("argument %d of %s is %v, should be %v", i+1, ident.Name, a.typ, f.args[i])
+		}
+		args[i] = a
+	}
+	return &whereExpr{f.ret, func(p *wherePair) interface{} {
+		vals := make([]interface{}, len(args))
+		for i, a := range args {
+			vals[i] = a.eval(p)
+		}
+		return f.call(vals)
+	}}, nil
+}
+
+// filter passes the pairs matching the expression, the runtime errors like
+// a division by zero fail the scan
+func (e *whereExpr) filter(key, val []byte) (ok bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("--where failed on key %s: %v", tikvclient.DisplayKey(key), r)
+		}
+	}()
+	return e.eval(&wherePair{key: key, val: val}).(bool), nil
+}